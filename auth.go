@@ -0,0 +1,377 @@
+package weft
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const principalKey contextKey = "principal"
+
+// Forbidden is for authenticated clients without the roles for a request.
+var Forbidden = Result{Ok: false, Code: http.StatusForbidden, Msg: "forbidden"}
+
+// ErrInvalidCredentials is returned by Authenticators for credentials that are present but not valid.
+// Errors from an Authenticator that are not ErrInvalidCredentials (or wrap it) are served as
+// http.StatusInternalServerError by Authenticate.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Principal is an authenticated client.
+type Principal struct {
+	Name   string                 // the user, key or token subject.
+	Roles  []string               // roles for authorization.
+	Scheme string                 // the authentication scheme e.g., "Basic".
+	Claims map[string]interface{} // JWT claims.  nil for other schemes.
+}
+
+// HasRole returns true if p has role.  A nil p has no roles.
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, v := range p.Roles {
+		if v == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Authenticator authenticates requests.  Implementations must be safe for concurrent use.
+
+Authenticate returns a nil Principal and nil error when r has no credentials for the
+Authenticator so that others can be tried.  Credentials that are present but not valid
+return an error.
+
+Challenge returns the WWW-Authenticate header value for 401 responses e.g., `Basic realm="weft"`.
+*/
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	Challenge() string
+}
+
+// Authenticators returns an Authenticator that tries each of a in order.
+func Authenticators(a ...Authenticator) Authenticator {
+	return authenticators(a)
+}
+
+type authenticators []Authenticator
+
+func (a authenticators) Authenticate(r *http.Request) (*Principal, error) {
+	for _, v := range a {
+		p, err := v.Authenticate(r)
+		if p != nil || err != nil {
+			return p, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (a authenticators) Challenge() string {
+	c := make([]string, 0, len(a))
+	for _, v := range a {
+		c = append(c, v.Challenge())
+	}
+
+	return strings.Join(c, ", ")
+}
+
+/*
+Authenticate authenticates r with a and checks the Principal has at least one of roles (if any).
+Returns r with the Principal in its context (see RequestPrincipal) and &StatusOK.
+
+Returns &Unauthorized with a WWW-Authenticate header set in h when r has no or invalid credentials,
+&Forbidden when the Principal doesn't have any of roles and http.StatusInternalServerError when
+a fails for any other reason e.g., reading the request body.
+
+Successful responses are private so they are not stored by shared caches including a
+ResponseCache, which doesn't store them even if the RequestHandler changes the headers.
+*/
+func Authenticate(r *http.Request, h http.Header, a Authenticator, roles ...string) (*http.Request, *Result) {
+	p, err := a.Authenticate(r)
+	if err != nil && !errors.Is(err, ErrInvalidCredentials) {
+		return r, InternalServerError(err)
+	}
+
+	if p == nil {
+		h.Set("WWW-Authenticate", a.Challenge())
+		return r, &Unauthorized
+	}
+
+	if len(roles) > 0 {
+		var ok bool
+		for _, role := range roles {
+			if p.HasRole(role) {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return r, &Forbidden
+		}
+	}
+
+	h.Set("Surrogate-Control", "max-age=0")
+	h.Set("Cache-Control", "private, max-age=0")
+	markAuthenticated(r)
+
+	return r.WithContext(context.WithValue(r.Context(), principalKey, p)), &StatusOK
+}
+
+// RequireAuth returns Middleware that authenticates requests, see Authenticate.
+// The wrapped RequestHandler is only called for authorized requests.
+func RequireAuth(a Authenticator, roles ...string) Middleware {
+	return func(f RequestHandler) RequestHandler {
+		return func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+			r, res := Authenticate(r, h, a, roles...)
+			if !res.Ok {
+				return res
+			}
+
+			return f(r, h, b)
+		}
+	}
+}
+
+// RequestPrincipal returns the Principal for an authenticated r or nil.
+func RequestPrincipal(r *http.Request) *Principal {
+	return PrincipalFromContext(r.Context())
+}
+
+// PrincipalFromContext returns the Principal from ctx or nil.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey).(*Principal)
+	return p
+}
+
+/*
+BasicAuth authenticates HTTP Basic credentials against password hashes.
+
+The hashes are checked with Check, which has the same signature as
+golang.org/x/crypto/bcrypt.CompareHashAndPassword so bcrypt hashes (e.g., from htpasswd -B)
+can be used without weft depending on a bcrypt package e.g.,
+
+	a := weft.NewBasicAuth("weft", bcrypt.CompareHashAndPassword)
+	err := a.ReadCredentialsFile("/etc/weft/credentials")
+
+Passwords for unknown users are checked against the hash for a known user so that the
+response time doesn't reveal which users exist.
+*/
+type BasicAuth struct {
+	realm string
+	check func(hash, password []byte) error
+
+	mu    sync.RWMutex
+	users map[string]basicUser
+	dummy []byte // hash checked for unknown users.
+}
+
+type basicUser struct {
+	hash  []byte
+	roles []string
+}
+
+// NewBasicAuth returns a BasicAuth for realm that checks passwords with check.
+func NewBasicAuth(realm string, check func(hash, password []byte) error) *BasicAuth {
+	return &BasicAuth{
+		realm: realm,
+		check: check,
+		users: make(map[string]basicUser),
+	}
+}
+
+/*
+ReadCredentials replaces the credentials for a with those read from r.  Each line is
+htpasswd style with optional comma separated roles.  Blank lines and lines starting with #
+are ignored e.g.,
+
+	# user:hash[:roles]
+	alice:$2y$10$...:admin,editor
+	bob:$2y$10$...
+*/
+func (a *BasicAuth) ReadCredentials(r io.Reader) error {
+	users := make(map[string]basicUser)
+	var dummy []byte
+
+	s := bufio.NewScanner(r)
+	var n int
+
+	for s.Scan() {
+		n++
+
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		p := strings.SplitN(l, ":", 3)
+		if len(p) < 2 || p[0] == "" || p[1] == "" {
+			return fmt.Errorf("credentials line %d: expected user:hash[:roles]", n)
+		}
+
+		u := basicUser{hash: []byte(p[1])}
+
+		if len(p) == 3 && p[2] != "" {
+			for _, role := range strings.Split(p[2], ",") {
+				if role = strings.TrimSpace(role); role != "" {
+					u.roles = append(u.roles, role)
+				}
+			}
+		}
+
+		users[p[0]] = u
+
+		if dummy == nil {
+			dummy = u.hash
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.users = users
+	a.dummy = dummy
+
+	return nil
+}
+
+// ReadCredentialsFile replaces the credentials for a with those from the file name.
+// See ReadCredentials.
+func (a *BasicAuth) ReadCredentialsFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return a.ReadCredentials(f)
+}
+
+func (a *BasicAuth) Authenticate(r *http.Request) (*Principal, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	a.mu.RLock()
+	u, ok := a.users[user]
+	dummy := a.dummy
+	a.mu.RUnlock()
+
+	if !ok {
+		// take as long as checking a known user.
+		if dummy != nil {
+			a.check(dummy, []byte(password))
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := a.check(u.hash, []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{Name: user, Roles: u.roles, Scheme: "Basic"}, nil
+}
+
+func (a *BasicAuth) Challenge() string {
+	return `Basic realm=` + quoteAuthParam(a.realm) + `, charset="UTF-8"`
+}
+
+// BearerAuth authenticates opaque bearer tokens e.g., API keys.
+type BearerAuth struct {
+	realm string
+
+	mu     sync.RWMutex
+	tokens map[[sha256.Size]byte]*Principal
+}
+
+// NewBearerAuth returns a BearerAuth for realm with no tokens.
+func NewBearerAuth(realm string) *BearerAuth {
+	return &BearerAuth{
+		realm:  realm,
+		tokens: make(map[[sha256.Size]byte]*Principal),
+	}
+}
+
+// AddToken adds token for the Principal p.  Only a hash of token is kept.
+func (a *BearerAuth) AddToken(token string, p Principal) {
+	p.Scheme = "Bearer"
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tokens[sha256.Sum256([]byte(token))] = &p
+}
+
+// RemoveToken removes token.
+func (a *BearerAuth) RemoveToken(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.tokens, sha256.Sum256([]byte(token)))
+}
+
+func (a *BearerAuth) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, nil
+	}
+
+	a.mu.RLock()
+	p, ok := a.tokens[sha256.Sum256([]byte(token))]
+	a.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	c := *p
+
+	return &c, nil
+}
+
+func (a *BearerAuth) Challenge() string {
+	return `Bearer realm=` + quoteAuthParam(a.realm)
+}
+
+// bearerToken returns the token from a Bearer Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	s, ok := authScheme(r, "Bearer")
+	if !ok || s == "" {
+		return "", false
+	}
+
+	return s, true
+}
+
+// authScheme returns the credentials from the Authorization header if it is for scheme.
+func authScheme(r *http.Request, scheme string) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < len(scheme)+1 || !strings.EqualFold(h[:len(scheme)], scheme) || h[len(scheme)] != ' ' {
+		return "", false
+	}
+
+	return strings.TrimSpace(h[len(scheme)+1:]), true
+}
+
+// quoteAuthParam returns s as a quoted string for a WWW-Authenticate parameter.
+func quoteAuthParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
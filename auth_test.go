@@ -0,0 +1,293 @@
+package weft
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// plainCheck compares hashes that are the password reversed.  Tests use this instead of bcrypt.
+func plainCheck(hash, password []byte) error {
+	if len(hash) != len(password) {
+		return errors.New("wrong password")
+	}
+
+	for i := range password {
+		if hash[i] != password[len(password)-1-i] {
+			return errors.New("wrong password")
+		}
+	}
+
+	return nil
+}
+
+func TestBasicAuth(t *testing.T) {
+	a := NewBasicAuth("weft", plainCheck)
+
+	err := a.ReadCredentials(strings.NewReader(`
+# user:hash[:roles]
+alice:terces:admin, editor
+bob:drowssap
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.ReadCredentials(strings.NewReader("alice")); err == nil {
+		t.Error("expected error for invalid credentials line")
+	}
+
+	in := []struct {
+		user, password string
+		roles          []string
+		code           int
+	}{
+		{user: "alice", password: "secret", code: http.StatusOK},
+		{user: "alice", password: "secret", roles: []string{"reader", "admin"}, code: http.StatusOK},
+		{user: "bob", password: "password", code: http.StatusOK},
+		{user: "bob", password: "password", roles: []string{"admin"}, code: http.StatusForbidden},
+		{user: "bob", password: "secret", code: http.StatusUnauthorized},
+		{user: "eve", password: "secret", code: http.StatusUnauthorized},
+		{code: http.StatusUnauthorized},
+	}
+
+	for i, v := range in {
+		r := httptest.NewRequest("PUT", "/tag/TAUP", nil)
+		if v.user != "" {
+			r.SetBasicAuth(v.user, v.password)
+		}
+
+		h := make(http.Header)
+
+		r, res := Authenticate(r, h, a, v.roles...)
+		if res.Code != v.code {
+			t.Errorf("%d expected %d got %d", i, v.code, res.Code)
+			continue
+		}
+
+		switch res.Code {
+		case http.StatusOK:
+			if p := RequestPrincipal(r); p == nil || p.Name != v.user || p.Scheme != "Basic" {
+				t.Errorf("%d unexpected principal %+v", i, p)
+			}
+			if h.Get("Cache-Control") != "private, max-age=0" {
+				t.Errorf("%d expected private response", i)
+			}
+		case http.StatusUnauthorized:
+			if h.Get("WWW-Authenticate") != `Basic realm="weft", charset="UTF-8"` {
+				t.Errorf("%d unexpected WWW-Authenticate %s", i, h.Get("WWW-Authenticate"))
+			}
+		}
+	}
+}
+
+func TestBasicAuthUnknownUser(t *testing.T) {
+	var checked [][]byte
+
+	a := NewBasicAuth("weft", func(hash, password []byte) error {
+		checked = append(checked, hash)
+		return plainCheck(hash, password)
+	})
+
+	r := httptest.NewRequest("PUT", "/tag/TAUP", nil)
+	r.SetBasicAuth("eve", "secret")
+
+	// no users, nothing to check against.
+	if _, err := a.Authenticate(r); err != ErrInvalidCredentials || len(checked) != 0 {
+		t.Errorf("expected invalid credentials and no check got %v %d", err, len(checked))
+	}
+
+	if err := a.ReadCredentials(strings.NewReader("alice:terces\nbob:drowssap")); err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown users take as long as known users.
+	if _, err := a.Authenticate(r); err != ErrInvalidCredentials || len(checked) != 1 || string(checked[0]) != "terces" {
+		t.Errorf("expected invalid credentials after checking a dummy hash got %v %q", err, checked)
+	}
+}
+
+// authFunc is an Authenticator for tests.
+type authFunc func(r *http.Request) (*Principal, error)
+
+func (f authFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+func (f authFunc) Challenge() string {
+	return "Test"
+}
+
+func TestAuthenticateErrors(t *testing.T) {
+	in := []struct {
+		err  error
+		code int
+	}{
+		{err: ErrInvalidCredentials, code: http.StatusUnauthorized},
+		{err: errJWT, code: http.StatusUnauthorized},
+		{err: io.ErrUnexpectedEOF, code: http.StatusInternalServerError},
+	}
+
+	for i, v := range in {
+		h := make(http.Header)
+
+		_, res := Authenticate(httptest.NewRequest("GET", "/quake", nil), h, authFunc(func(r *http.Request) (*Principal, error) {
+			return nil, v.err
+		}))
+
+		if res.Code != v.code {
+			t.Errorf("%d expected %d got %d", i, v.code, res.Code)
+		}
+
+		if (res.Code == http.StatusUnauthorized) != (h.Get("WWW-Authenticate") == "Test") {
+			t.Errorf("%d unexpected WWW-Authenticate %s", i, h.Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	b := NewBearerAuth("weft")
+	b.AddToken("abc123", Principal{Name: "quake-feed", Roles: []string{"reader"}})
+
+	a := Authenticators(NewBasicAuth("weft", plainCheck), b)
+
+	r := httptest.NewRequest("GET", "/quake", nil)
+	r.Header.Set("Authorization", "Bearer abc123")
+
+	p, err := a.Authenticate(r)
+	if err != nil || p == nil || p.Name != "quake-feed" || !p.HasRole("reader") || p.Scheme != "Bearer" {
+		t.Errorf("unexpected principal %+v %v", p, err)
+	}
+
+	r.Header.Set("Authorization", "Bearer wrong")
+	if _, err := a.Authenticate(r); err != ErrInvalidCredentials {
+		t.Errorf("expected invalid credentials got %v", err)
+	}
+
+	b.RemoveToken("abc123")
+	r.Header.Set("Authorization", "Bearer abc123")
+	if _, err := a.Authenticate(r); err != ErrInvalidCredentials {
+		t.Errorf("expected invalid credentials for removed token got %v", err)
+	}
+
+	if c := a.Challenge(); c != `Basic realm="weft", charset="UTF-8", Bearer realm="weft"` {
+		t.Errorf("unexpected challenge %s", c)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	a := NewHMACAuth("weft", 5*time.Minute)
+	a.AddKey("sensor-1", []byte("secret"), Principal{Roles: []string{"writer"}})
+
+	sign := func(body, secret string) *http.Request {
+		r, err := http.NewRequest("PUT", "http://test.com/data?sensor=1", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := SignRequest(r, "sensor-1", []byte(secret)); err != nil {
+			t.Fatal(err)
+		}
+
+		// the body can still be read after signing.
+		if b, _ := io.ReadAll(r.Body); string(b) != body {
+			t.Errorf("expected body %s got %s", body, b)
+		}
+		r.Body = io.NopCloser(strings.NewReader(body))
+
+		return r
+	}
+
+	r := sign("1.5", "secret")
+
+	p, err := a.Authenticate(r)
+	if err != nil || p == nil || p.Name != "sensor-1" || !p.HasRole("writer") {
+		t.Fatalf("unexpected principal %+v %v", p, err)
+	}
+
+	if b, _ := io.ReadAll(r.Body); string(b) != "1.5" {
+		t.Errorf("expected body to be readable after authentication got %s", b)
+	}
+
+	if _, err := a.Authenticate(sign("1.5", "wrong")); err == nil {
+		t.Error("expected error for wrong secret")
+	}
+
+	r = sign("1.5", "secret")
+	r.Body = io.NopCloser(strings.NewReader("2.5"))
+	if _, err := a.Authenticate(r); err == nil {
+		t.Error("expected error for changed body")
+	}
+
+	r = sign("1.5", "secret")
+	r.Header.Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	if _, err := a.Authenticate(r); err == nil {
+		t.Error("expected error for old Date")
+	}
+
+	a.SetMaxBytes(2)
+
+	r = sign("1.5", "secret")
+	if _, err := a.Authenticate(r); err != ErrInvalidCredentials {
+		t.Errorf("expected invalid credentials for a large body got %v", err)
+	}
+
+	// without a Content-Length.
+	r = sign("1.5", "secret")
+	r.ContentLength = -1
+	if _, err := a.Authenticate(r); err != ErrInvalidCredentials {
+		t.Errorf("expected invalid credentials for a large streamed body got %v", err)
+	}
+
+	a.SetMaxBytes(3)
+
+	if p, err := a.Authenticate(sign("1.5", "secret")); err != nil || p == nil {
+		t.Errorf("expected principal for a body at the limit got %+v %v", p, err)
+	}
+
+	r = httptest.NewRequest("GET", "/data", nil)
+	if p, err := a.Authenticate(r); p != nil || err != nil {
+		t.Error("expected no principal or error without credentials")
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	a := NewBearerAuth("weft")
+	a.AddToken("abc123", Principal{Name: "admin", Roles: []string{"admin"}})
+	a.AddToken("def456", Principal{Name: "reader", Roles: []string{"reader"}})
+
+	f := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString("deleted by " + RequestPrincipal(r).Name)
+		return &StatusOK
+	}, WithMiddleware(RequireAuth(a, "admin")))
+
+	in := []struct {
+		token string
+		code  int
+		body  string
+		sc    string
+	}{
+		{token: "abc123", code: http.StatusOK, body: "deleted by admin", sc: "max-age=0"},
+		{token: "def456", code: http.StatusForbidden, body: "forbidden", sc: "max-age=0"},
+		{code: http.StatusUnauthorized, body: "Access denied", sc: "max-age=0"},
+	}
+
+	for i, v := range in {
+		r := httptest.NewRequest("DELETE", "/tag/TAUP", nil)
+		if v.token != "" {
+			r.Header.Set("Authorization", "Bearer "+v.token)
+		}
+
+		w := httptest.NewRecorder()
+		f(w, r)
+
+		if w.Code != v.code || w.Body.String() != v.body || w.Header().Get("Surrogate-Control") != v.sc {
+			t.Errorf("%d expected %d %s %s got %d %s %s", i, v.code, v.body, v.sc, w.Code, w.Body.String(), w.Header().Get("Surrogate-Control"))
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package weft
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupportedType is returned by a Decoder when it can't decode into the type of v.
+var ErrUnsupportedType = errors.New("unsupported type for decoding")
+
+// Decoder decodes a request body p into v.
+type Decoder interface {
+	Decode(p []byte, v interface{}) error
+}
+
+// DecoderFunc is an adapter to allow the use of ordinary functions as a Decoder.
+type DecoderFunc func(p []byte, v interface{}) error
+
+func (f DecoderFunc) Decode(p []byte, v interface{}) error {
+	return f(p, v)
+}
+
+// Validator is implemented by values that check their own fields after decoding.
+// See DecodeBody.
+type Validator interface {
+	Validate() error
+}
+
+// decoders holds the registered Decoders by media type.
+var decoders = struct {
+	sync.RWMutex
+	m map[string]Decoder
+}{
+	m: map[string]Decoder{
+		"application/json":                  DecoderFunc(decodeJSON),
+		"application/x-www-form-urlencoded": DecoderFunc(decodeForm),
+		"application/x-protobuf":            DecoderFunc(decodeProtobuf),
+		"text/csv":                          DecoderFunc(decodeCSV),
+	},
+}
+
+/*
+RegisterDecoder makes d available to DecodeBody for request bodies with the media type
+e.g., "application/xml".  It replaces any Decoder already registered for mediaType.
+A nil d removes mediaType.
+
+Decoders are registered by default for:
+
+	application/json - v is anything encoding/json can unmarshal into.
+	application/x-www-form-urlencoded - v is *url.Values or a pointer to a struct with `form:"name"` field tags.
+	application/x-protobuf - v has an Unmarshal([]byte) error method e.g., generated protobuf types.
+	text/csv - v is *[][]string.
+*/
+func RegisterDecoder(mediaType string, d Decoder) {
+	mediaType = strings.ToLower(mediaType)
+
+	decoders.Lock()
+	defer decoders.Unlock()
+
+	if d == nil {
+		delete(decoders.m, mediaType)
+		return
+	}
+
+	decoders.m[mediaType] = d
+}
+
+/*
+DecodeBody reads the body of r, up to maxBytes, and decodes it into v with the Decoder for the
+request Content-Type.  If mediaTypes are given then the Content-Type must be one of them.
+If v is a Validator then it is validated after decoding.
+
+Returns:
+
+	http.StatusRequestEntityTooLarge - the body is larger than maxBytes.
+	http.StatusUnsupportedMediaType - the Content-Type is not allowed or has no Decoder.
+	http.StatusBadRequest - the body can't be decoded or v is not valid.
+	http.StatusInternalServerError - the Decoder can't decode into the type of v.
+*/
+func DecodeBody(r *http.Request, v interface{}, maxBytes int64, mediaTypes ...string) *Result {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return unsupportedMediaType("missing or invalid Content-Type")
+	}
+
+	if len(mediaTypes) > 0 {
+		var ok bool
+		for _, m := range mediaTypes {
+			if strings.EqualFold(m, mt) {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return unsupportedMediaType("Content-Type must be one of: " + strings.Join(mediaTypes, ", "))
+		}
+	}
+
+	decoders.RLock()
+	d, ok := decoders.m[mt]
+	decoders.RUnlock()
+
+	if !ok {
+		return unsupportedMediaType("unsupported Content-Type " + mt)
+	}
+
+	if r.ContentLength > maxBytes {
+		return tooLarge(maxBytes)
+	}
+
+	if r.Body == nil {
+		return BadRequest("empty request body")
+	}
+
+	b := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(b)
+	b.Reset()
+
+	// read one more than allowed to find bodies that are too large.
+	if _, err := b.ReadFrom(io.LimitReader(r.Body, maxBytes+1)); err != nil {
+		return BadRequest("error reading request body: " + err.Error())
+	}
+
+	if int64(b.Len()) > maxBytes {
+		return tooLarge(maxBytes)
+	}
+
+	if err := d.Decode(b.Bytes(), v); err != nil {
+		if errors.Is(err, ErrUnsupportedType) {
+			return InternalServerError(err)
+		}
+		return BadRequest("error decoding " + mt + " request body: " + err.Error())
+	}
+
+	if val, ok := v.(Validator); ok {
+		if err := val.Validate(); err != nil {
+			return BadRequest("invalid request body: " + err.Error())
+		}
+	}
+
+	return &StatusOK
+}
+
+func unsupportedMediaType(msg string) *Result {
+	return &Result{Ok: false, Code: http.StatusUnsupportedMediaType, Msg: msg}
+}
+
+func tooLarge(maxBytes int64) *Result {
+	return &Result{Ok: false, Code: http.StatusRequestEntityTooLarge, Msg: fmt.Sprintf("request body larger than %d bytes", maxBytes)}
+}
+
+func decodeJSON(p []byte, v interface{}) error {
+	return json.Unmarshal(p, v)
+}
+
+func decodeProtobuf(p []byte, v interface{}) error {
+	m, ok := v.(interface {
+		Unmarshal([]byte) error
+	})
+	if !ok {
+		return fmt.Errorf("%w: %T has no Unmarshal method", ErrUnsupportedType, v)
+	}
+
+	return m.Unmarshal(p)
+}
+
+func decodeCSV(p []byte, v interface{}) error {
+	rows, ok := v.(*[][]string)
+	if !ok {
+		return fmt.Errorf("%w: %T is not *[][]string", ErrUnsupportedType, v)
+	}
+
+	var err error
+	*rows, err = csv.NewReader(bytes.NewReader(p)).ReadAll()
+
+	return err
+}
+
+// decodeForm decodes url encoded form p into *url.Values or fields of a struct tagged with `form:"name"`.
+// Struct fields may be string, bool, int, uint and float kinds or slices of them.
+func decodeForm(p []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(p))
+	if err != nil {
+		return err
+	}
+
+	if u, ok := v.(*url.Values); ok {
+		*u = values
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %T is not *url.Values or a pointer to a struct", ErrUnsupportedType, v)
+	}
+
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		name := rt.Field(i).Tag.Get("form")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		f := rv.Field(i)
+		if !f.CanSet() {
+			return fmt.Errorf("%w: field %s tagged %s is unexported", ErrUnsupportedType, rt.Field(i).Name, name)
+		}
+
+		s, ok := values[name]
+		if !ok {
+			continue
+		}
+
+		if f.Kind() == reflect.Slice {
+			sl := reflect.MakeSlice(f.Type(), len(s), len(s))
+			for j := range s {
+				if err := setField(sl.Index(j), s[j]); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+			f.Set(sl)
+			continue
+		}
+
+		if err := setField(f, s[0]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// setField sets f from s converting s to the kind of f.
+func setField(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(x)
+	default:
+		return fmt.Errorf("%w: field kind %s", ErrUnsupportedType, f.Kind())
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package weft
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type site struct {
+	Code      string   `json:"code" form:"code"`
+	Latitude  float64  `json:"latitude" form:"latitude"`
+	Elevation int      `json:"elevation" form:"elevation"`
+	Tags      []string `json:"tags" form:"tag"`
+}
+
+func (s *site) Validate() error {
+	if s.Code == "" {
+		return errors.New("code is required")
+	}
+	return nil
+}
+
+type proto struct {
+	p []byte
+}
+
+func (m *proto) Unmarshal(p []byte) error {
+	m.p = p
+	return nil
+}
+
+func TestDecodeBody(t *testing.T) {
+	in := []struct {
+		contentType string
+		body        string
+		mediaTypes  []string
+		code        int
+	}{
+		{contentType: "application/json", body: `{"code":"WGTN","latitude":-41.3,"elevation":26}`, code: http.StatusOK},
+		{contentType: "application/json; charset=utf-8", body: `{"code":"WGTN"}`, mediaTypes: []string{"application/json"}, code: http.StatusOK},
+		{contentType: "application/x-www-form-urlencoded", body: "code=WGTN&latitude=-41.3&elevation=26&tag=a&tag=b", code: http.StatusOK},
+		{contentType: "application/x-www-form-urlencoded", body: "code=WGTN&elevation=high", code: http.StatusBadRequest},
+		{contentType: "application/json", body: `{"latitude":-41.3}`, code: http.StatusBadRequest},
+		{contentType: "application/json", body: `{"code":`, code: http.StatusBadRequest},
+		{contentType: "application/json", body: `{"code":"` + strings.Repeat("W", 100) + `"}`, code: http.StatusRequestEntityTooLarge},
+		{contentType: "application/x-www-form-urlencoded", body: "code=WGTN", mediaTypes: []string{"application/json"}, code: http.StatusUnsupportedMediaType},
+		{contentType: "application/xml", body: "<site/>", code: http.StatusUnsupportedMediaType},
+		{contentType: "", body: `{"code":"WGTN"}`, code: http.StatusUnsupportedMediaType},
+		{contentType: "text/csv", body: "WGTN,-41.3", code: http.StatusInternalServerError},
+	}
+
+	for i, v := range in {
+		r, err := http.NewRequest("PUT", "http://test.com/site", strings.NewReader(v.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.contentType != "" {
+			r.Header.Set("Content-Type", v.contentType)
+		}
+
+		var s site
+
+		res := DecodeBody(r, &s, 64, v.mediaTypes...)
+		if res.Code != v.code {
+			t.Errorf("%d expected code %d got %d: %s", i, v.code, res.Code, res.Msg)
+		}
+
+		if res.Code == http.StatusOK && s.Code != "WGTN" {
+			t.Errorf("%d expected code WGTN got %s", i, s.Code)
+		}
+	}
+
+	r, err := http.NewRequest("PUT", "http://test.com/site", strings.NewReader("code=WGTN&latitude=-41.3&elevation=26&tag=a&tag=b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var s site
+	if res := DecodeBody(r, &s, 1024); !res.Ok {
+		t.Fatal(res.Msg)
+	}
+
+	if s.Latitude != -41.3 || s.Elevation != 26 || len(s.Tags) != 2 || s.Tags[1] != "b" {
+		t.Errorf("unexpected form decode %+v", s)
+	}
+}
+
+func TestDecodeBodyTypes(t *testing.T) {
+	r, err := http.NewRequest("PUT", "http://test.com/site", strings.NewReader("code,latitude\nWGTN,-41.3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "text/csv")
+
+	var rows [][]string
+	if res := DecodeBody(r, &rows, 1024); !res.Ok {
+		t.Fatal(res.Msg)
+	}
+
+	if len(rows) != 2 || rows[1][0] != "WGTN" {
+		t.Errorf("unexpected csv decode %v", rows)
+	}
+
+	r, err = http.NewRequest("PUT", "http://test.com/site", strings.NewReader("code=WGTN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var u url.Values
+	if res := DecodeBody(r, &u, 1024); !res.Ok {
+		t.Fatal(res.Msg)
+	}
+
+	if u.Get("code") != "WGTN" {
+		t.Errorf("expected WGTN got %s", u.Get("code"))
+	}
+
+	r, err = http.NewRequest("PUT", "http://test.com/site", strings.NewReader("\x0a\x04WGTN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-protobuf")
+
+	var m proto
+	if res := DecodeBody(r, &m, 1024); !res.Ok {
+		t.Fatal(res.Msg)
+	}
+
+	if string(m.p) != "\x0a\x04WGTN" {
+		t.Errorf("unexpected protobuf body %q", m.p)
+	}
+
+	RegisterDecoder("application/xml", DecoderFunc(func(p []byte, v interface{}) error {
+		v.(*site).Code = "XML"
+		return nil
+	}))
+	defer RegisterDecoder("application/xml", nil)
+
+	r, err = http.NewRequest("PUT", "http://test.com/site", strings.NewReader("<site/>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/xml")
+
+	var s site
+	if res := DecodeBody(r, &s, 1024); !res.Ok || s.Code != "XML" {
+		t.Errorf("expected registered xml decoder got %s", res.Msg)
+	}
+}
+
+func TestDecodeFormUnsupported(t *testing.T) {
+	in := []struct {
+		v    interface{}
+		code int
+	}{
+		{v: &struct {
+			code string `form:"code"`
+		}{}, code: http.StatusInternalServerError},
+		{v: &struct {
+			Code map[string]string `form:"code"`
+		}{}, code: http.StatusInternalServerError},
+		{v: &struct {
+			Code []complex64 `form:"code"`
+		}{}, code: http.StatusInternalServerError},
+		{v: &struct {
+			Code int `form:"code"`
+		}{}, code: http.StatusBadRequest},
+	}
+
+	for i, v := range in {
+		r, err := http.NewRequest("PUT", "http://test.com/site", strings.NewReader("code=WGTN"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		if res := DecodeBody(r, v.v, 1024); res.Code != v.code {
+			t.Errorf("%d expected code %d got %d: %s", i, v.code, res.Code, res.Msg)
+		}
+	}
+}
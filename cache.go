@@ -0,0 +1,320 @@
+package weft
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ResponseCache stores compressed responses in memory so that repeat requests skip
+both the RequestHandler and compression.  Use it with WithCache e.g.,
+
+	var cache = weft.NewResponseCache(1000, 64<<20)
+	...
+	mux.HandleFunc("/quake", weft.MakeHandlerAPI(quakeHandler, weft.WithCache(cache)))
+
+Only successful (http.StatusOK) GET responses are cached.  They are cached for
+the max-age in the Surrogate-Control header of the response.  The cache is shared
+between clients so requests with an Authorization header or that are authenticated
+(see Authenticate) and responses that are Cache-Control private, no-store or no-cache
+or that set a cookie are not cached.  Rate limit headers (see RateLimit) are not cached.
+Responses are keyed by URL, the Accept header (that the RequestHandler uses to negotiate
+Content-Type) and the negotiated content coding.
+
+Conditional and Range requests are served from the cached response.
+
+When the cache is full the least recently used responses are evicted.
+Responses can be removed by surrogate key with Purge.
+A ResponseCache is safe for concurrent use.
+*/
+type ResponseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	size       int
+	ll         *list.List
+	entries    map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	header  http.Header
+	body    []byte
+	expires time.Time
+	keys    keySet // surrogate keys for purging.
+}
+
+const authenticatedKey contextKey = "authenticated"
+
+// clientHeaders are response headers for a single client that are not cached e.g., from RateLimit.
+var clientHeaders = map[string]bool{
+	"Ratelimit-Limit":     true,
+	"Ratelimit-Remaining": true,
+	"Ratelimit-Reset":     true,
+	"Retry-After":         true,
+}
+
+// cacheWriter records a response as it is written to the client.
+// before is the header before the handler was called.  Headers that are
+// unchanged from it are per request e.g., X-Request-ID and are not cached.
+type cacheWriter struct {
+	http.ResponseWriter
+	code          int
+	b             bytes.Buffer
+	before        http.Header
+	authenticated int32 // set by markAuthenticated, possibly from a timed out handler.
+}
+
+// withCacheWriter returns r with a context that lets markAuthenticated flag w.
+func withCacheWriter(r *http.Request, w *cacheWriter) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authenticatedKey, &w.authenticated))
+}
+
+// markAuthenticated stops the response to r being cached.  Headers can't be relied on
+// for this as the RequestHandler can change them after Authenticate.
+func markAuthenticated(r *http.Request) {
+	if a, ok := r.Context().Value(authenticatedKey).(*int32); ok {
+		atomic.StoreInt32(a, 1)
+	}
+}
+
+/*
+NewResponseCache returns a ResponseCache that stores up to maxEntries responses
+and maxBytes of response bodies.  Zero means no limit.
+*/
+func NewResponseCache(maxEntries, maxBytes int) *ResponseCache {
+	return &ResponseCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// WithCache caches responses in c.
+func WithCache(c *ResponseCache) Option {
+	return func(h *handler) {
+		h.cache = c
+	}
+}
+
+// Len returns the number of responses in the cache.
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.b.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// cacheKey returns the key for a response to r.
+func cacheKey(r *http.Request) string {
+	coding, _ := encoder(r.Header.Get("Accept-Encoding"))
+
+	return r.URL.RequestURI() + "\x00" + r.Header.Get("Accept") + "\x00" + coding
+}
+
+// serve writes the cached response for r to w.  Returns false if
+// there is no response for r in the cache.
+func (c *ResponseCache) serve(w http.ResponseWriter, r *http.Request) bool {
+	key := cacheKey(r)
+
+	c.mu.Lock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+
+	entry := e.Value.(*cacheEntry)
+
+	if time.Now().After(entry.expires) {
+		c.remove(e)
+		c.mu.Unlock()
+		return false
+	}
+
+	c.ll.MoveToFront(e)
+	c.mu.Unlock()
+
+	// entries are not modified once stored so can be used without the lock.
+	for k, v := range entry.header {
+		w.Header()[k] = v
+	}
+
+	if notModified(r, w.Header()) {
+		writeNotModified(w)
+		return true
+	}
+
+	// only identity responses are stored with Accept-Ranges.
+	if r.Header.Get("Range") != "" && w.Header().Get("Accept-Ranges") == "bytes" && ifRange(r, w.Header()) {
+		serveRange(w, r, entry.body)
+		return true
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.body)
+
+	return true
+}
+
+// store adds the response recorded in w to the cache if it can be cached.
+func (c *ResponseCache) store(r *http.Request, w *cacheWriter) {
+	if w.code != http.StatusOK {
+		return
+	}
+
+	age := maxAge(w.Header().Get("Surrogate-Control"))
+	if age <= 0 {
+		return
+	}
+
+	if c.maxBytes > 0 && w.b.Len() > c.maxBytes {
+		return
+	}
+
+	if atomic.LoadInt32(&w.authenticated) != 0 || !shared(r, w.Header()) {
+		return
+	}
+
+	entry := &cacheEntry{
+		key:     cacheKey(r),
+		header:  make(http.Header, len(w.Header())),
+		body:    make([]byte, w.b.Len()),
+		expires: time.Now().Add(time.Duration(age) * time.Second),
+	}
+
+	for k, v := range w.Header() {
+		if clientHeaders[k] || equalValues(v, w.before[k]) {
+			continue
+		}
+		entry.header[k] = append([]string(nil), v...)
+	}
+
+	if k := splitKeys(entry.header[surrogateKeyHeader.name]); len(k) > 0 {
+		entry.keys = make(keySet, len(k))
+		for _, v := range k {
+			entry.keys[v] = true
+		}
+	}
+
+	copy(entry.body, w.b.Bytes())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[entry.key]; ok {
+		c.remove(e)
+	}
+
+	c.entries[entry.key] = c.ll.PushFront(entry)
+	c.size += len(entry.body)
+
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.ll.Back())
+	}
+}
+
+// shared returns true if the response with header h for r can be stored in a shared cache.
+func shared(r *http.Request, h http.Header) bool {
+	if r.Header.Get("Authorization") != "" || h.Get("Set-Cookie") != "" {
+		return false
+	}
+
+	for _, d := range strings.Split(h.Get("Cache-Control"), ",") {
+		if i := strings.Index(d, "="); i > 0 {
+			d = d[:i]
+		}
+
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case "private", "no-store", "no-cache":
+			return false
+		}
+	}
+
+	return true
+}
+
+// equalValues returns true if the header values a and b are the same.
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Purge removes responses tagged with any of the surrogate keys from the cache.
+// A ResponseCache is a Purger.
+func (c *ResponseCache) Purge(keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var next *list.Element
+
+	for e := c.ll.Front(); e != nil; e = next {
+		next = e.Next()
+
+		if e.Value.(*cacheEntry).keys.hasAny(keys) {
+			c.remove(e)
+		}
+	}
+
+	return nil
+}
+
+// remove deletes e from the cache.  The caller must hold c.mu.
+func (c *ResponseCache) remove(e *list.Element) {
+	entry := c.ll.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= len(entry.body)
+}
+
+// maxAge returns the max-age in seconds from a Surrogate-Control or
+// Cache-Control header value s.  Returns 0 if there is no max-age.
+func maxAge(s string) int {
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimSpace(d)
+		if !strings.HasPrefix(d, "max-age=") {
+			continue
+		}
+
+		i, err := strconv.Atoi(strings.TrimPrefix(d, "max-age="))
+		if err != nil {
+			return 0
+		}
+
+		return i
+	}
+
+	return 0
+}
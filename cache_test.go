@@ -0,0 +1,324 @@
+package weft
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	var calls int
+
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		calls++
+		h.Set("Content-Type", "text/plain")
+		if r.URL.Query().Get("age") != "" {
+			h.Set("Surrogate-Control", "max-age="+r.URL.Query().Get("age"))
+		}
+		b.WriteString("bogan impsum bogan impsum")
+		b.WriteString("bogan impsum bogan impsum")
+		return &StatusOK
+	}
+
+	c := NewResponseCache(2, 0)
+	fm := MakeHandlerAPI(f, WithCache(c))
+
+	do := func(url, acceptEncoding string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		return w
+	}
+
+	e := "bogan impsum bogan impsumbogan impsum bogan impsum"
+
+	w := do("http://test.com/a", "gzip")
+	checkResponse(t, w, http.StatusOK, "max-age=10", "gzip", e)
+
+	w = do("http://test.com/a", "gzip")
+	checkResponse(t, w, http.StatusOK, "max-age=10", "gzip", e)
+
+	if calls != 1 {
+		t.Errorf("expected 1 call to the handler got %d", calls)
+	}
+
+	// a different coding is a different response.
+	w = do("http://test.com/a", "")
+	checkResponse(t, w, http.StatusOK, "max-age=10", "", e)
+
+	if calls != 2 {
+		t.Errorf("expected 2 calls to the handler got %d", calls)
+	}
+
+	// max-age=0 is not cached.
+	do("http://test.com/a?age=0", "")
+	do("http://test.com/a?age=0", "")
+
+	if calls != 4 {
+		t.Errorf("expected 4 calls to the handler got %d", calls)
+	}
+
+	if c.Len() != 2 {
+		t.Errorf("expected 2 cache entries got %d", c.Len())
+	}
+
+	// adding another entry evicts the least recently used (/a with gzip).
+	do("http://test.com/b", "")
+	do("http://test.com/a", "")
+	do("http://test.com/a", "gzip")
+
+	if calls != 6 {
+		t.Errorf("expected 6 calls to the handler got %d", calls)
+	}
+
+	// expired responses are not served.
+	c.mu.Lock()
+	for _, v := range c.entries {
+		v.Value.(*cacheEntry).expires = time.Now().Add(-time.Second)
+	}
+	c.mu.Unlock()
+
+	do("http://test.com/a", "gzip")
+
+	if calls != 7 {
+		t.Errorf("expected 7 calls to the handler got %d", calls)
+	}
+}
+
+func TestResponseCacheMaxBytes(t *testing.T) {
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString(r.URL.Path)
+		return &StatusOK
+	}
+
+	c := NewResponseCache(0, 10)
+	fm := MakeHandlerAPI(f, WithCache(c))
+
+	for _, u := range []string{"http://test.com/aaaa", "http://test.com/bbbb", "http://test.com/cccc", "http://test.com/larger-than-max"} {
+		r, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if c.Len() != 2 {
+		t.Errorf("expected 2 cache entries got %d", c.Len())
+	}
+
+	if c.size > 10 {
+		t.Errorf("cache size %d exceeds max bytes", c.size)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	in := map[string]int{
+		"":                          0,
+		"max-age=10":                10,
+		"no-store, max-age=86400":   86400,
+		"max-age=bad":               0,
+		"stale-while-revalidate=10": 0,
+	}
+
+	for k, v := range in {
+		if maxAge(k) != v {
+			t.Errorf("%s expected %d got %d", k, v, maxAge(k))
+		}
+	}
+}
+
+func TestResponseCacheNotModified(t *testing.T) {
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString("bogan impsum bogan impsum")
+		return &StatusOK
+	}
+
+	fm := MakeHandlerAPI(f, WithCache(NewResponseCache(10, 0)))
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	// the second request is served from the cache.
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusNotModified, "max-age=10", "", "")
+}
+
+// TestResponseCacheRequestID checks a cached response has the request ID for
+// the request it is served to, not the request it was stored from.
+func TestResponseCacheRequestID(t *testing.T) {
+	fm := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString("bogan impsum")
+		return &StatusOK
+	}, WithCache(NewResponseCache(2, 0)))
+
+	for _, id := range []string{"first-request", "second-request"} {
+		r := httptest.NewRequest("GET", "http://test.com/a", nil)
+		r.Header.Set("X-Request-ID", id)
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		checkResponse(t, w, http.StatusOK, "max-age=10", "", "bogan impsum")
+
+		if w.Header().Get("X-Request-ID") != id {
+			t.Errorf("expected X-Request-ID %s got %s", id, w.Header().Get("X-Request-ID"))
+		}
+	}
+}
+
+// TestResponseCacheShared checks responses for one client are not stored in the shared cache.
+func TestResponseCacheShared(t *testing.T) {
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		switch r.URL.Path {
+		case "/private":
+			h.Set("Cache-Control", "private, max-age=60")
+		case "/no-store":
+			h.Set("Cache-Control", "no-store")
+		case "/no-cache":
+			h.Set("Cache-Control", `no-cache="Set-Cookie"`)
+		case "/cookie":
+			h.Set("Set-Cookie", "session=abc")
+		}
+		b.WriteString("bogan impsum")
+		return &StatusOK
+	}
+
+	c := NewResponseCache(10, 0)
+	fm := MakeHandlerAPI(f, WithCache(c))
+
+	for _, p := range []string{"/private", "/no-store", "/no-cache", "/cookie", "/auth"} {
+		r := httptest.NewRequest("GET", "http://test.com"+p, nil)
+		if p == "/auth" {
+			r.Header.Set("Authorization", "Bearer abc123")
+		}
+		fm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if c.Len() != 0 {
+		t.Errorf("expected no cache entries got %d", c.Len())
+	}
+
+	fm.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.com/public", nil))
+
+	if c.Len() != 1 {
+		t.Errorf("expected 1 cache entry got %d", c.Len())
+	}
+}
+
+// keyAuth authenticates requests with an X-API-Key header.
+type keyAuth string
+
+func (k keyAuth) Authenticate(r *http.Request) (*Principal, error) {
+	switch r.Header.Get("X-API-Key") {
+	case "":
+		return nil, nil
+	case string(k):
+		return &Principal{Name: "key"}, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+func (k keyAuth) Challenge() string {
+	return "API-Key"
+}
+
+// authenticated responses are not cached even if the handler makes them public.
+func TestResponseCacheAuthenticated(t *testing.T) {
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		h.Set("Surrogate-Control", "max-age=60")
+		h.Set("Cache-Control", "max-age=60")
+		b.WriteString("secret for " + RequestPrincipal(r).Name)
+		return &StatusOK
+	}
+
+	c := NewResponseCache(10, 0)
+
+	for _, fm := range []http.HandlerFunc{
+		MakeHandlerAPI(f, WithCache(c), WithMiddleware(RequireAuth(keyAuth("abc123")))),
+		MakeHandlerAPI(f, WithCache(c), WithMiddleware(RequireAuth(keyAuth("abc123"))), WithTimeout(time.Second)),
+	} {
+		r := httptest.NewRequest("GET", "http://test.com/secret", nil)
+		r.Header.Set("X-API-Key", "abc123")
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+		checkResponse(t, w, http.StatusOK, "max-age=60", "", "secret for key")
+
+		w = httptest.NewRecorder()
+		fm.ServeHTTP(w, httptest.NewRequest("GET", "http://test.com/secret", nil))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 without credentials got %d %s", w.Code, w.Body.String())
+		}
+
+		if c.Len() != 0 {
+			t.Errorf("expected no cache entries got %d", c.Len())
+		}
+	}
+}
+
+func TestResponseCacheRange(t *testing.T) {
+	var calls int
+
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		calls++
+		b.WriteString("bogan impsum bogan impsum")
+		return &StatusOK
+	}
+
+	fm := MakeHandlerAPI(f, WithCache(NewResponseCache(10, 0)))
+
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, httptest.NewRequest("GET", "http://test.com/a", nil))
+
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatal("expected Accept-Ranges for an identity response")
+	}
+
+	etag := w.Header().Get("ETag")
+
+	r := httptest.NewRequest("GET", "http://test.com/a", nil)
+	r.Header.Set("Range", "bytes=0-4")
+
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "bogan" || w.Header().Get("Content-Range") != "bytes 0-4/25" {
+		t.Errorf("expected partial content from the cache got %d %q %s", w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+	}
+
+	// a stale If-Range gets the whole response.
+	r.Header.Set("If-Range", `"stale"`)
+
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Body.String() != "bogan impsum bogan impsum" {
+		t.Errorf("expected full response for stale If-Range got %d", w.Code)
+	}
+
+	r.Header.Set("If-Range", etag)
+
+	w = httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected partial content for matching If-Range got %d", w.Code)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected 1 call to the handler got %d", calls)
+	}
+}
@@ -0,0 +1,143 @@
+package weft
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+CacheRule describes the caching headers for a response.
+
+Surrogate-Control is always written.  Cache-Control is only written when
+Public or Private is set.  Without them any Cache-Control set by a RequestHandler
+is removed for status codes other than http.StatusOK.
+*/
+type CacheRule struct {
+	SurrogateMaxAge      int      // Surrogate-Control max-age in seconds for intermediate caches e.g., a CDN.
+	Public               bool     // Cache-Control public.
+	Private              bool     // Cache-Control private.  Takes precedence over Public.
+	MaxAge               int      // Cache-Control max-age in seconds for clients.
+	SMaxAge              int      // Cache-Control s-maxage in seconds.  Omitted when zero.
+	StaleWhileRevalidate int      // Cache-Control stale-while-revalidate in seconds.  Omitted when zero.
+	StaleIfError         int      // Cache-Control stale-if-error in seconds.  Omitted when zero.
+	SurrogateKeys        []string // surrogate keys added to any from AddSurrogateKeys.  See SetSurrogateKeyHeader.
+}
+
+/*
+CachePolicy sets caching headers for responses by status code.
+
+For http.StatusOK the headers set by a RequestHandler are respected and
+the policy only sets headers the handler did not.  For other status codes the
+policy headers overwrite any set by the handler.
+*/
+type CachePolicy struct {
+	Default CacheRule         // the rule for status codes not in Status.
+	Status  map[int]CacheRule // rules by HTTP status code.
+}
+
+// DefaultCachePolicy is the CachePolicy used by weft unless SetCachePolicy or WithCachePolicy are used.
+// Changes to it are not safe while serving requests.
+var DefaultCachePolicy = CachePolicy{
+	Default: CacheRule{SurrogateMaxAge: 10},
+	Status: map[int]CacheRule{
+		http.StatusNotFound:            {SurrogateMaxAge: 10},
+		http.StatusServiceUnavailable:  {SurrogateMaxAge: 10},
+		http.StatusInternalServerError: {SurrogateMaxAge: 10},
+		http.StatusBadRequest:          {SurrogateMaxAge: 86400},
+		http.StatusMethodNotAllowed:    {SurrogateMaxAge: 86400},
+		http.StatusMovedPermanently:    {SurrogateMaxAge: 86400},
+		http.StatusUnauthorized:        {SurrogateMaxAge: 0, Private: true},
+		http.StatusForbidden:           {SurrogateMaxAge: 0, Private: true},
+		http.StatusTooManyRequests:     {SurrogateMaxAge: 0, Private: true},
+	},
+}
+
+// cachePolicy is the policy for Write, WriteBytes and handlers made without WithCachePolicy.
+var cachePolicy = &DefaultCachePolicy
+
+/*
+SetCachePolicy sets the CachePolicy used for Write, WriteBytes and handlers made
+without WithCachePolicy.  A nil p restores DefaultCachePolicy.
+
+SetCachePolicy should be called before serving requests.
+*/
+func SetCachePolicy(p *CachePolicy) {
+	if p == nil {
+		p = &DefaultCachePolicy
+	}
+	cachePolicy = p
+}
+
+// WithCachePolicy sets the CachePolicy for a handler.
+func WithCachePolicy(p *CachePolicy) Option {
+	return func(h *handler) {
+		h.cachePolicy = p
+	}
+}
+
+// Rule returns the CacheRule for the HTTP status code.
+func (p *CachePolicy) Rule(code int) CacheRule {
+	if c, ok := p.Status[code]; ok {
+		return c
+	}
+
+	return p.Default
+}
+
+/*
+apply sets the caching headers in h for a response with the HTTP status code.
+For http.StatusOK headers that are already set in h are not changed.
+*/
+func (p *CachePolicy) apply(h http.Header, code int) {
+	c := p.Rule(code)
+
+	set := func(key, value string) {
+		if value == "" {
+			if code != http.StatusOK {
+				h.Del(key)
+			}
+			return
+		}
+
+		if code == http.StatusOK && h.Get(key) != "" {
+			return
+		}
+
+		h.Set(key, value)
+	}
+
+	set("Surrogate-Control", "max-age="+strconv.Itoa(c.SurrogateMaxAge))
+	set("Cache-Control", c.cacheControl())
+	writeSurrogateKeys(h, c.SurrogateKeys)
+}
+
+// cacheControl returns the Cache-Control header value for c.
+func (c CacheRule) cacheControl() string {
+	var d []string
+
+	switch {
+	case c.Private:
+		d = append(d, "private")
+	case c.Public:
+		d = append(d, "public")
+	default:
+		return ""
+	}
+
+	d = append(d, "max-age="+strconv.Itoa(c.MaxAge))
+
+	if c.SMaxAge > 0 {
+		d = append(d, "s-maxage="+strconv.Itoa(c.SMaxAge))
+	}
+
+	if c.StaleWhileRevalidate > 0 {
+		d = append(d, "stale-while-revalidate="+strconv.Itoa(c.StaleWhileRevalidate))
+	}
+
+	if c.StaleIfError > 0 {
+		d = append(d, "stale-if-error="+strconv.Itoa(c.StaleIfError))
+	}
+
+	return strings.Join(d, ", ")
+}
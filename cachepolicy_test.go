@@ -0,0 +1,117 @@
+package weft
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCachePolicy(t *testing.T) {
+	p := &CachePolicy{
+		Default: CacheRule{SurrogateMaxAge: 60, Public: true, MaxAge: 30, StaleIfError: 3600},
+		Status: map[int]CacheRule{
+			http.StatusNotFound: {SurrogateMaxAge: 5, Private: true, Public: true, SurrogateKeys: []string{"missing", "quake"}},
+			http.StatusOK:       {SurrogateMaxAge: 300, Public: true, MaxAge: 60, SMaxAge: 120, StaleWhileRevalidate: 30},
+		},
+	}
+
+	var code int
+	var surrogate string
+
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		if surrogate != "" {
+			h.Set("Surrogate-Control", surrogate)
+		}
+		return &Result{Code: code}
+	}
+
+	fm := MakeHandlerAPI(f, WithCachePolicy(p))
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []struct {
+		code                               int
+		surrogate                          string
+		surrogateControl, cc, surrogateKey string
+	}{
+		{code: http.StatusOK, surrogateControl: "max-age=300", cc: "public, max-age=60, s-maxage=120, stale-while-revalidate=30"},
+		// handler set headers are respected for 200
+		{code: http.StatusOK, surrogate: "max-age=1", surrogateControl: "max-age=1", cc: "public, max-age=60, s-maxage=120, stale-while-revalidate=30"},
+		// and overwritten for others.
+		{code: http.StatusNotFound, surrogate: "max-age=1", surrogateControl: "max-age=5", cc: "private, max-age=0", surrogateKey: "missing quake"},
+		{code: http.StatusServiceUnavailable, surrogateControl: "max-age=60", cc: "public, max-age=30, stale-if-error=3600"},
+	}
+
+	for i, v := range in {
+		code = v.code
+		surrogate = v.surrogate
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Header().Get("Surrogate-Control") != v.surrogateControl {
+			t.Errorf("%d expected Surrogate-Control %s got %s", i, v.surrogateControl, w.Header().Get("Surrogate-Control"))
+		}
+
+		if w.Header().Get("Cache-Control") != v.cc {
+			t.Errorf("%d expected Cache-Control %s got %s", i, v.cc, w.Header().Get("Cache-Control"))
+		}
+
+		if w.Header().Get("Surrogate-Key") != v.surrogateKey {
+			t.Errorf("%d expected Surrogate-Key %s got %s", i, v.surrogateKey, w.Header().Get("Surrogate-Key"))
+		}
+	}
+
+	// other handlers are not changed.
+	w := httptest.NewRecorder()
+	MakeHandlerAPI(f).ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusServiceUnavailable, "max-age=10", "", "")
+
+	if w.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected no Cache-Control got %s", w.Header().Get("Cache-Control"))
+	}
+}
+
+// TestCachePolicyRemovesCacheControl checks a handler's Cache-Control isn't sent
+// with an error when the rule for the error has none.
+func TestCachePolicyRemovesCacheControl(t *testing.T) {
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		h.Set("Cache-Control", "public, max-age=86400")
+		if r.URL.Path == "/ok" {
+			return &StatusOK
+		}
+		return &Result{Code: http.StatusInternalServerError, Msg: "database down"}
+	}
+
+	w := httptest.NewRecorder()
+	MakeHandlerAPI(f).ServeHTTP(w, httptest.NewRequest("GET", "http://test.com/broken", nil))
+
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected 500 with no Cache-Control got %d %s", w.Code, w.Header().Get("Cache-Control"))
+	}
+
+	w = httptest.NewRecorder()
+	MakeHandlerAPI(f).ServeHTTP(w, httptest.NewRequest("GET", "http://test.com/ok", nil))
+
+	if w.Header().Get("Cache-Control") != "public, max-age=86400" {
+		t.Errorf("expected handler Cache-Control for 200 got %s", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestSetCachePolicy(t *testing.T) {
+	SetCachePolicy(&CachePolicy{Default: CacheRule{SurrogateMaxAge: 42}})
+	defer SetCachePolicy(nil)
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	Write(w, r, BadRequest("bad"))
+	checkResponse(t, w, http.StatusBadRequest, "max-age=42", "", "bad")
+}
@@ -0,0 +1,201 @@
+package weft
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// minCompressSize is the size in bytes a response body must exceed to be compressed.
+var minCompressSize = 20
+
+/*
+SetMinCompressSize sets the size in bytes a response body must exceed before
+it is compressed.  The default is 20.  Small responses grow when compressed.
+
+SetMinCompressSize should be called before serving requests.
+*/
+func SetMinCompressSize(n int) {
+	minCompressSize = n
+}
+
+// gzipEncoder is a gzip Encoder that reuses writers.
+type gzipEncoder struct {
+	level int
+	pool  sync.Pool
+}
+
+// deflateEncoder is a deflate (zlib format) Encoder that reuses writers.
+type deflateEncoder struct {
+	level int
+	pool  sync.Pool
+}
+
+// brotliEncoder is a br Encoder that reuses writers.
+type brotliEncoder struct {
+	level int
+	pool  sync.Pool
+}
+
+// zstdEncoder is a zstd Encoder that reuses writers.
+type zstdEncoder struct {
+	level zstd.EncoderLevel
+	pool  sync.Pool
+}
+
+/*
+NewGzipEncoder returns an Encoder for gzip compression at level e.g., gzip.BestSpeed.
+gzip writers are pooled between responses.  Register it to change the compression
+level for responses e.g.,
+
+	e, err := weft.NewGzipEncoder(gzip.BestSpeed)
+	...
+	weft.RegisterEncoder("gzip", e)
+*/
+func NewGzipEncoder(level int) (Encoder, error) {
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		return nil, err
+	}
+
+	return &gzipEncoder{level: level}, nil
+}
+
+// NewDeflateEncoder returns an Encoder for deflate compression at level e.g., zlib.BestSpeed.
+// zlib writers are pooled between responses.
+func NewDeflateEncoder(level int) (Encoder, error) {
+	if _, err := zlib.NewWriterLevel(nil, level); err != nil {
+		return nil, err
+	}
+
+	return &deflateEncoder{level: level}, nil
+}
+
+// NewBrotliEncoder returns an Encoder for br compression at level e.g., brotli.BestSpeed.
+// brotli writers are pooled between responses.
+func NewBrotliEncoder(level int) (Encoder, error) {
+	if level < brotli.BestSpeed || level > brotli.BestCompression {
+		return nil, fmt.Errorf("brotli: invalid compression level: %d", level)
+	}
+
+	return &brotliEncoder{level: level}, nil
+}
+
+// NewZstdEncoder returns an Encoder for zstd compression at level e.g., zstd.SpeedFastest.
+// zstd writers are pooled between responses.
+func NewZstdEncoder(level zstd.EncoderLevel) (Encoder, error) {
+	if _, err := newZstdWriter(nil, level); err != nil {
+		return nil, err
+	}
+
+	return &zstdEncoder{level: level}, nil
+}
+
+// newZstdWriter returns a zstd writer for level that encodes synchronously.
+// The window is limited to 8MB so all HTTP clients can decode the response.
+func newZstdWriter(w io.Writer, level zstd.EncoderLevel) (*zstd.Encoder, error) {
+	return zstd.NewWriter(w,
+		zstd.WithEncoderLevel(level),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(8<<20))
+}
+
+func (e *gzipEncoder) Encode(w io.Writer, p []byte) error {
+	var gz *gzip.Writer
+
+	if v := e.pool.Get(); v != nil {
+		gz = v.(*gzip.Writer)
+		gz.Reset(w)
+	} else {
+		var err error
+		// level is checked by NewGzipEncoder.
+		if gz, err = gzip.NewWriterLevel(w, e.level); err != nil {
+			return err
+		}
+	}
+
+	_, err := gz.Write(p)
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+
+	// don't hold a reference to w while pooled.
+	gz.Reset(nil)
+	e.pool.Put(gz)
+
+	return err
+}
+
+func (e *deflateEncoder) Encode(w io.Writer, p []byte) error {
+	var z *zlib.Writer
+
+	if v := e.pool.Get(); v != nil {
+		z = v.(*zlib.Writer)
+		z.Reset(w)
+	} else {
+		var err error
+		if z, err = zlib.NewWriterLevel(w, e.level); err != nil {
+			return err
+		}
+	}
+
+	_, err := z.Write(p)
+	if cerr := z.Close(); err == nil {
+		err = cerr
+	}
+
+	z.Reset(nil)
+	e.pool.Put(z)
+
+	return err
+}
+
+func (e *brotliEncoder) Encode(w io.Writer, p []byte) error {
+	var br *brotli.Writer
+
+	if v := e.pool.Get(); v != nil {
+		br = v.(*brotli.Writer)
+		br.Reset(w)
+	} else {
+		br = brotli.NewWriterLevel(w, e.level)
+	}
+
+	_, err := br.Write(p)
+	if cerr := br.Close(); err == nil {
+		err = cerr
+	}
+
+	br.Reset(nil)
+	e.pool.Put(br)
+
+	return err
+}
+
+func (e *zstdEncoder) Encode(w io.Writer, p []byte) error {
+	var z *zstd.Encoder
+
+	if v := e.pool.Get(); v != nil {
+		z = v.(*zstd.Encoder)
+		z.Reset(w)
+	} else {
+		var err error
+		// level is checked by NewZstdEncoder.
+		if z, err = newZstdWriter(w, e.level); err != nil {
+			return err
+		}
+	}
+
+	_, err := z.Write(p)
+	if cerr := z.Close(); err == nil {
+		err = cerr
+	}
+
+	z.Reset(nil)
+	e.pool.Put(z)
+
+	return err
+}
@@ -0,0 +1,127 @@
+package weft
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNewGzipEncoder(t *testing.T) {
+	if _, err := NewGzipEncoder(42); err == nil {
+		t.Error("expected error for invalid compression level")
+	}
+
+	if _, err := NewDeflateEncoder(42); err == nil {
+		t.Error("expected error for invalid compression level")
+	}
+
+	e, err := NewGzipEncoder(gzip.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// use the encoder more than once to reuse a pooled writer.
+	for i := 0; i < 3; i++ {
+		var b bytes.Buffer
+
+		if err := e.Encode(&b, []byte("bogan impsum bogan impsum")); err != nil {
+			t.Fatal(err)
+		}
+
+		gz, err := gzip.NewReader(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var d bytes.Buffer
+		d.ReadFrom(gz)
+
+		if d.String() != "bogan impsum bogan impsum" {
+			t.Errorf("got wrong body %s", d.String())
+		}
+	}
+}
+
+func TestNewBrotliEncoder(t *testing.T) {
+	if _, err := NewBrotliEncoder(42); err == nil {
+		t.Error("expected error for invalid compression level")
+	}
+
+	if _, err := NewZstdEncoder(42); err == nil {
+		t.Error("expected error for invalid compression level")
+	}
+
+	br, err := NewBrotliEncoder(brotli.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewZstdEncoder(zstd.SpeedFastest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	// use the encoders more than once to reuse pooled writers.
+	for i := 0; i < 3; i++ {
+		var b bytes.Buffer
+
+		if err := br.Encode(&b, []byte("bogan impsum bogan impsum")); err != nil {
+			t.Fatal(err)
+		}
+
+		var d bytes.Buffer
+		d.ReadFrom(brotli.NewReader(&b))
+
+		if d.String() != "bogan impsum bogan impsum" {
+			t.Errorf("br got wrong body %s", d.String())
+		}
+
+		b.Reset()
+
+		if err := z.Encode(&b, []byte("bogan impsum bogan impsum")); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := dec.DecodeAll(b.Bytes(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(p) != "bogan impsum bogan impsum" {
+			t.Errorf("zstd got wrong body %s", p)
+		}
+	}
+}
+
+func TestSetMinCompressSize(t *testing.T) {
+	SetMinCompressSize(100)
+	defer SetMinCompressSize(20)
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	var b bytes.Buffer
+	b.WriteString("bogan impsum bogan impsum")
+	b.WriteString("bogan impsum bogan impsum")
+
+	w := httptest.NewRecorder()
+	WriteBytes(w, r, &Result{Code: http.StatusOK}, &b, false)
+
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Error("response smaller than the min compress size should not be compressed")
+	}
+}
@@ -0,0 +1,106 @@
+package weft
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	errSaturated    = errors.New("too many requests in progress")
+	errQueueTimeout = errors.New("timed out waiting for a request to finish")
+)
+
+/*
+WithConcurrencyLimit limits the handler to inFlight requests at once.  Up to queue more requests
+wait, for at most wait, for a request to finish.  Requests that find the queue full, wait too long,
+or whose client disconnects while waiting are served http.StatusServiceUnavailable straight away.
+
+Requests that time out with WithTimeout keep their place until the handler returns so that
+slow handlers don't pile up.  Responses served from a ResponseCache are not limited.
+The queue depth and rejections are recorded with Metrics that implement LoadMetrics.
+*/
+func WithConcurrencyLimit(inFlight, queue int, wait time.Duration) Option {
+	return func(h *handler) {
+		if inFlight < 1 {
+			inFlight = 1
+		}
+
+		if queue < 0 {
+			queue = 0
+		}
+
+		h.limit = &concurrencyLimit{
+			slots: make(chan struct{}, inFlight),
+			queue: make(chan struct{}, queue),
+			wait:  wait,
+		}
+	}
+}
+
+// concurrencyLimit is a semaphore with a bounded wait queue.
+type concurrencyLimit struct {
+	slots chan struct{}
+	queue chan struct{}
+	wait  time.Duration
+}
+
+// acquire takes a slot, waiting in the queue if there is space.  handler is the handler
+// name for LoadMetrics.
+func (c *concurrencyLimit) acquire(ctx context.Context, handler string) error {
+	select {
+	case c.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	lm := loadMetrics()
+
+	select {
+	case c.queue <- struct{}{}:
+	default:
+		if lm != nil {
+			lm.Rejected(handler)
+		}
+		return errSaturated
+	}
+
+	if lm != nil {
+		lm.Queue(handler, len(c.queue))
+	}
+
+	defer func() {
+		<-c.queue
+		if lm != nil {
+			lm.Queue(handler, len(c.queue))
+		}
+	}()
+
+	var timeout <-chan time.Time
+	if c.wait > 0 {
+		t := time.NewTimer(c.wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		if lm != nil {
+			lm.Rejected(handler)
+		}
+		return errQueueTimeout
+	case <-ctx.Done():
+		return errors.New("client disconnected")
+	}
+}
+
+// release returns a slot.  It does nothing for a nil c.
+func (c *concurrencyLimit) release() {
+	if c == nil {
+		return
+	}
+
+	<-c.slots
+}
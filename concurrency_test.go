@@ -0,0 +1,117 @@
+package weft
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	p := NewPrometheusMetrics()
+	SetMetrics(p)
+	defer SetMetrics(nil)
+
+	started := make(chan bool)
+	unblock := make(chan bool)
+
+	f := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		started <- true
+		<-unblock
+		b.WriteString("ok")
+		return &StatusOK
+	}, WithConcurrencyLimit(1, 1, time.Minute))
+
+	codes := make(chan int, 3)
+	var wg sync.WaitGroup
+
+	serve := func() {
+		defer wg.Done()
+		w := httptest.NewRecorder()
+		f(w, httptest.NewRequest("GET", "/slow", nil))
+		codes <- w.Code
+	}
+
+	// the first request is in flight.
+	wg.Add(1)
+	go serve()
+	<-started
+
+	// the second request waits in the queue.
+	wg.Add(1)
+	go serve()
+
+	for i := 0; i < 100 && !strings.Contains(promText(p), `weft_queue_depth{handler="func1"} 1`); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !strings.Contains(promText(p), `weft_queue_depth{handler="func1"} 1`) {
+		t.Fatalf("expected a queued request:\n%s", promText(p))
+	}
+
+	// the third request finds the queue full.
+	w := httptest.NewRecorder()
+	f(w, httptest.NewRequest("GET", "/slow", nil))
+
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != errSaturated.Error() {
+		t.Errorf("expected 503 %s got %d %s", errSaturated.Error(), w.Code, w.Body.String())
+	}
+
+	unblock <- true
+	<-started
+	unblock <- true
+
+	wg.Wait()
+	close(codes)
+
+	for c := range codes {
+		if c != http.StatusOK {
+			t.Errorf("expected 200 got %d", c)
+		}
+	}
+
+	m := promText(p)
+
+	for _, l := range []string{
+		`weft_queue_depth{handler="func1"} 0`,
+		`weft_rejected_total{handler="func1"} 1`,
+		`weft_requests_total{handler="func1",method="GET",code="503",class="5xx"} 1`,
+	} {
+		if !strings.Contains(m, l+"\n") {
+			t.Errorf("expected %s in:\n%s", l, m)
+		}
+	}
+}
+
+func TestConcurrencyLimitWait(t *testing.T) {
+	c := &concurrencyLimit{
+		slots: make(chan struct{}, 1),
+		queue: make(chan struct{}, 1),
+		wait:  10 * time.Millisecond,
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+
+	if err := c.acquire(r.Context(), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.acquire(r.Context(), "test"); err != errQueueTimeout {
+		t.Errorf("expected queue timeout got %v", err)
+	}
+
+	c.release()
+
+	if err := c.acquire(r.Context(), "test"); err != nil {
+		t.Errorf("expected slot after release got %v", err)
+	}
+}
+
+func promText(p *PrometheusMetrics) string {
+	var b bytes.Buffer
+	p.write(&b)
+	return b.String()
+}
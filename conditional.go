@@ -0,0 +1,134 @@
+package weft
+
+import (
+	"bytes"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etag returns a strong entity tag for the response body p.
+func etag(p []byte) string {
+	h := fnv.New64a()
+	h.Write(p)
+
+	return `"` + strconv.FormatUint(h.Sum64(), 16) + `"`
+}
+
+// weakenETag makes a strong ETag in h weak.  Used when the response is
+// content encoded and is no longer byte for byte the same as the strong ETag.
+func weakenETag(h http.Header) {
+	if e := h.Get("ETag"); e != "" && !strings.HasPrefix(e, "W/") {
+		h.Set("ETag", "W/"+e)
+	}
+}
+
+/*
+notModified returns true if the conditional request headers in r match the
+response headers h so that http.StatusNotModified should be served.
+If-Modified-Since is ignored when If-None-Match is present.
+*/
+func notModified(r *http.Request, h http.Header) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		e := h.Get("ETag")
+		if e == "" {
+			return false
+		}
+
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || weakMatch(t, e) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	lm := h.Get("Last-Modified")
+	if ims == "" || lm == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+
+	modified, err := http.ParseTime(lm)
+	if err != nil {
+		return false
+	}
+
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// weakMatch compares the entity tags a and b with the weak comparison function.
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// writeNotModified writes http.StatusNotModified to w.  Representation headers
+// that don't apply to a 304 are removed.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+
+	if h.Get("ETag") != "" {
+		h.Del("Last-Modified")
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+}
+
+/*
+ifRange returns true if a Range request should be served as a range.
+That is when there is no If-Range header or it matches the response
+headers h.  Entity tags must match with the strong comparison function
+and dates must exactly match Last-Modified.
+*/
+func ifRange(r *http.Request, h http.Header) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		e := h.Get("ETag")
+		return e != "" && !strings.HasPrefix(ir, "W/") && !strings.HasPrefix(e, "W/") && ir == e
+	}
+
+	t, err := http.ParseTime(ir)
+	if err != nil {
+		return false
+	}
+
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+
+	return t.Equal(lm)
+}
+
+// serveRange writes the ranges requested by r from b to w.  Ranges are not compressed.
+func serveRange(w http.ResponseWriter, r *http.Request, b []byte) {
+	var modified time.Time
+
+	if lm := w.Header().Get("Last-Modified"); lm != "" {
+		modified, _ = http.ParseTime(lm)
+	}
+
+	w.Header().Del("Content-Encoding")
+
+	http.ServeContent(w, r, "", modified, bytes.NewReader(b))
+}
@@ -0,0 +1,235 @@
+package weft
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriteBytesETag(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := Result{Code: http.StatusOK}
+	var b bytes.Buffer
+
+	body := "bogan impsum bogan impsum bogan impsum"
+
+	b.WriteString(body)
+	w := httptest.NewRecorder()
+	WriteBytes(w, r, &res, &b, false)
+	checkResponse(t, w, http.StatusOK, "max-age=10", "", body)
+
+	e := w.Header().Get("ETag")
+	if e == "" || e[0] != '"' {
+		t.Fatalf("expected a strong ETag got %s", e)
+	}
+
+	// matching If-None-Match gets 304 with no body.
+	r.Header.Set("If-None-Match", `"other", `+e)
+	b.Reset()
+	b.WriteString(body)
+	w = httptest.NewRecorder()
+	WriteBytes(w, r, &res, &b, false)
+	checkResponse(t, w, http.StatusNotModified, "max-age=10", "", "")
+
+	if w.Header().Get("ETag") != e {
+		t.Errorf("expected ETag %s with 304 got %s", e, w.Header().Get("ETag"))
+	}
+
+	if w.Header().Get("Content-Type") != "" {
+		t.Error("expected no Content-Type with 304")
+	}
+
+	// compressed responses have a weak ETag that still matches.
+	r.Header.Del("If-None-Match")
+	r.Header.Set("Accept-Encoding", "gzip")
+	b.Reset()
+	b.WriteString(body)
+	w = httptest.NewRecorder()
+	WriteBytes(w, r, &res, &b, false)
+	checkResponse(t, w, http.StatusOK, "max-age=10", "gzip", body)
+
+	if w.Header().Get("ETag") != "W/"+e {
+		t.Errorf("expected weak ETag W/%s got %s", e, w.Header().Get("ETag"))
+	}
+
+	r.Header.Set("If-None-Match", "W/"+e)
+	b.Reset()
+	b.WriteString(body)
+	w = httptest.NewRecorder()
+	WriteBytes(w, r, &res, &b, false)
+	checkResponse(t, w, http.StatusNotModified, "max-age=10", "", "")
+
+	// a different body doesn't match.
+	b.Reset()
+	b.WriteString("bogan impsum")
+	w = httptest.NewRecorder()
+	WriteBytes(w, r, &res, &b, false)
+	checkResponse(t, w, http.StatusOK, "max-age=10", "", "bogan impsum")
+
+	// a handler supplied ETag is used.
+	r.Header.Set("If-None-Match", `"v1"`)
+	w = httptest.NewRecorder()
+	w.Header().Set("ETag", `"v1"`)
+	b.Reset()
+	b.WriteString("bogan impsum")
+	WriteBytes(w, r, &res, &b, false)
+	checkResponse(t, w, http.StatusNotModified, "max-age=10", "", "")
+
+	// only GET and HEAD are conditional.
+	r.Method = "PUT"
+	w = httptest.NewRecorder()
+	b.Reset()
+	b.WriteString("bogan impsum")
+	WriteBytes(w, r, &res, &b, false)
+	checkResponse(t, w, http.StatusOK, "max-age=10", "", "bogan impsum")
+
+	// error responses are not conditional.
+	r.Method = "GET"
+	r.Header.Set("If-None-Match", "*")
+	w = httptest.NewRecorder()
+	WriteBytes(w, r, &NotFound, &b, false)
+	checkResponse(t, w, http.StatusNotFound, "max-age=10", "", NotFound.Msg)
+}
+
+func TestWriteBytesLastModified(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := Result{Code: http.StatusOK}
+	var b bytes.Buffer
+
+	modified := time.Date(2017, 5, 29, 10, 0, 0, 0, time.UTC)
+
+	in := []struct {
+		since string
+		code  int
+	}{
+		{since: modified.Format(http.TimeFormat), code: http.StatusNotModified},
+		{since: modified.Add(time.Hour).Format(http.TimeFormat), code: http.StatusNotModified},
+		{since: modified.Add(-time.Hour).Format(http.TimeFormat), code: http.StatusOK},
+		{since: "not a time", code: http.StatusOK},
+	}
+
+	for i, v := range in {
+		r.Header.Set("If-Modified-Since", v.since)
+		b.Reset()
+		b.WriteString("bogan impsum")
+
+		w := httptest.NewRecorder()
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		WriteBytes(w, r, &res, &b, false)
+
+		if w.Code != v.code {
+			t.Errorf("%d expected status %d got %d", i, v.code, w.Code)
+		}
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since.
+	r.Header.Set("If-Modified-Since", modified.Format(http.TimeFormat))
+	r.Header.Set("If-None-Match", `"nope"`)
+	w := httptest.NewRecorder()
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	WriteBytes(w, r, &res, &b, false)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 got %d", w.Code)
+	}
+}
+
+func TestWriteBytesRange(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	res := Result{Code: http.StatusOK}
+	var b bytes.Buffer
+
+	body := "0123456789bogan impsum bogan impsum"
+
+	write := func() *httptest.ResponseRecorder {
+		b.Reset()
+		b.WriteString(body)
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "text/plain")
+		WriteBytes(w, r, &res, &b, false)
+		return w
+	}
+
+	// full responses advertise ranges when not compressed.
+	w := write()
+	checkResponse(t, w, http.StatusOK, "max-age=10", "gzip", body)
+
+	if w.Header().Get("Accept-Ranges") != "" {
+		t.Errorf("expected no Accept-Ranges got %s", w.Header().Get("Accept-Ranges"))
+	}
+
+	r.Header.Del("Accept-Encoding")
+	w = write()
+	checkResponse(t, w, http.StatusOK, "max-age=10", "", body)
+	etag := w.Header().Get("ETag")
+
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("expected Accept-Ranges bytes got %s", w.Header().Get("Accept-Ranges"))
+	}
+
+	// ranges are not compressed.
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=0-9")
+	w = write()
+	checkResponse(t, w, http.StatusPartialContent, "max-age=10", "", "0123456789")
+
+	if w.Header().Get("Content-Range") != "bytes 0-9/35" {
+		t.Errorf("expected Content-Range bytes 0-9/35 got %s", w.Header().Get("Content-Range"))
+	}
+
+	r.Header.Set("Range", "bytes=-6")
+	w = write()
+	checkResponse(t, w, http.StatusPartialContent, "max-age=10", "", "impsum")
+
+	r.Header.Set("Range", "bytes=0-1,5-6")
+	w = write()
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected status 206 got %d", w.Code)
+	}
+
+	if mt := w.Header().Get("Content-Type"); len(mt) < 20 || mt[:20] != "multipart/byteranges" {
+		t.Errorf("expected multipart/byteranges got %s", mt)
+	}
+
+	r.Header.Set("Range", "bytes=100-200")
+	w = write()
+
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected status 416 got %d", w.Code)
+	}
+
+	if w.Header().Get("Content-Range") != "bytes */35" {
+		t.Errorf("expected Content-Range bytes */35 got %s", w.Header().Get("Content-Range"))
+	}
+
+	// If-Range matching the strong ETag gets the range.
+	r.Header.Set("Range", "bytes=0-9")
+	r.Header.Set("If-Range", etag)
+	w = write()
+	checkResponse(t, w, http.StatusPartialContent, "max-age=10", "", "0123456789")
+
+	// If-Range not matching gets the full (compressed) response.
+	r.Header.Set("If-Range", `"stale"`)
+	w = write()
+	checkResponse(t, w, http.StatusOK, "max-age=10", "gzip", body)
+
+	r.Header.Set("If-Range", "W/"+etag)
+	w = write()
+	checkResponse(t, w, http.StatusOK, "max-age=10", "gzip", body)
+}
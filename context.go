@@ -0,0 +1,117 @@
+package weft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ContextRequestHandler is a RequestHandler that is passed the context for the request.
+// It should stop work and return when ctx is done.  See WithTimeout.
+type ContextRequestHandler func(ctx context.Context, r *http.Request, h http.Header, b *bytes.Buffer) *Result
+
+/*
+WithTimeout limits the time a handler has to serve a request to d.  The request context
+is cancelled when d passes or when the client disconnects.  The handler is not waited
+for; http.StatusServiceUnavailable is served straight away and anything the handler
+writes afterwards is discarded.
+
+Handlers should use the request context with any slow calls, e.g., database queries,
+so that they stop work once it is done.  See ContextRequestHandler.
+*/
+func WithTimeout(d time.Duration) Option {
+	return func(h *handler) {
+		h.timeout = d
+	}
+}
+
+// MakeHandlerPageContext is MakeHandlerPage for a ContextRequestHandler.
+func MakeHandlerPageContext(f ContextRequestHandler, opts ...Option) http.HandlerFunc {
+	return newHandler(f.handler(), name(f), true, opts).ServeHTTP
+}
+
+// MakeHandlerAPIContext is MakeHandlerAPI for a ContextRequestHandler.
+func MakeHandlerAPIContext(f ContextRequestHandler, opts ...Option) http.HandlerFunc {
+	return newHandler(f.handler(), name(f), false, opts).ServeHTTP
+}
+
+// handler adapts f to a RequestHandler.
+func (f ContextRequestHandler) handler() RequestHandler {
+	return func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return f(r.Context(), r, h, b)
+	}
+}
+
+/*
+call executes h.f with header and b, applying any concurrency limit and timeout.  Returns the Result
+and the buffer holding the response body.  When the handler times out b is left
+with the handler and a different buffer from bufferPool is returned.
+*/
+func (h *handler) call(r *http.Request, header http.Header, b *bytes.Buffer) (*Result, *bytes.Buffer) {
+	if h.limit != nil {
+		if err := h.limit.acquire(r.Context(), h.name); err != nil {
+			return ServiceUnavailableError(err), b
+		}
+	}
+
+	if h.timeout <= 0 {
+		defer h.limit.release()
+		return h.safe(r, header, b), b
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	r = r.WithContext(ctx)
+
+	// f writes to its own copy of header so nothing it does after a timeout changes the response.
+	fh := header.Clone()
+	fb := b
+	done := make(chan *Result, 1)
+
+	go func() {
+		defer h.limit.release()
+		defer func() {
+			// safe only panics with http.ErrAbortHandler.  Pass it back
+			// as a nil Result to abort from the goroutine serving r.
+			if v := recover(); v != nil {
+				done <- nil
+			}
+		}()
+
+		done <- h.safe(r, fh, fb)
+	}()
+
+	select {
+	case res := <-done:
+		if res == nil {
+			panic(http.ErrAbortHandler)
+		}
+
+		for k := range header {
+			delete(header, k)
+		}
+		for k, v := range fh {
+			header[k] = v
+		}
+		return res, b
+	case <-ctx.Done():
+		// the handler owns fb until it returns.
+		go func() {
+			<-done
+			bufferPool.Put(fb)
+		}()
+
+		err := errors.New("timed out after " + h.timeout.String())
+		if errors.Is(ctx.Err(), context.Canceled) {
+			err = errors.New("client disconnected")
+		}
+
+		b = bufferPool.Get().(*bytes.Buffer)
+		b.Reset()
+
+		return ServiceUnavailableError(err), b
+	}
+}
@@ -0,0 +1,74 @@
+package weft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	stopped := make(chan error, 1)
+
+	slow := func(ctx context.Context, r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		h.Set("X-Slow", "true")
+		select {
+		case <-time.After(time.Second):
+			b.WriteString("too slow")
+			return &StatusOK
+		case <-ctx.Done():
+			stopped <- ctx.Err()
+			return ServiceUnavailableError(ctx.Err())
+		}
+	}
+
+	fast := func(ctx context.Context, r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		if h.Get("X-Request-ID") == "" {
+			return InternalServerError(errors.New("expected headers set before the handler"))
+		}
+		h.Set("X-Fast", "true")
+		b.WriteString("fast")
+		return &StatusOK
+	}
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	MakeHandlerPageContext(slow, WithTimeout(10*time.Millisecond)).ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusServiceUnavailable, "max-age=10", "", err503)
+
+	if w.Header().Get("X-Slow") != "" {
+		t.Error("headers from a handler that timed out should not be written")
+	}
+
+	select {
+	case err := <-stopped:
+		if err != context.DeadlineExceeded {
+			t.Errorf("expected deadline exceeded got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("handler did not see the context done")
+	}
+
+	w = httptest.NewRecorder()
+	MakeHandlerAPIContext(fast, WithTimeout(time.Second)).ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusOK, "max-age=10", "", "fast")
+
+	if w.Header().Get("X-Fast") != "true" {
+		t.Error("expected headers from the handler")
+	}
+
+	// client disconnects.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w = httptest.NewRecorder()
+	MakeHandlerAPIContext(slow, WithTimeout(time.Second)).ServeHTTP(w, r.WithContext(ctx))
+	checkResponse(t, w, http.StatusServiceUnavailable, "max-age=10", "", "client disconnected")
+}
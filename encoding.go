@@ -0,0 +1,160 @@
+package weft
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoder writes p to w with a content coding e.g., gzip.
+type Encoder interface {
+	Encode(w io.Writer, p []byte) error
+}
+
+// EncoderFunc is an adapter to allow the use of ordinary functions as an Encoder.
+type EncoderFunc func(w io.Writer, p []byte) error
+
+func (f EncoderFunc) Encode(w io.Writer, p []byte) error {
+	return f(w, p)
+}
+
+// brotliLevel is the default br compression level.  Higher levels are too slow
+// to compress dynamic responses.
+const brotliLevel = 4
+
+// encoders holds the registered content codings in order of server preference.
+// This is used to break ties when the client gives codings the same q-value.
+var encoders = struct {
+	sync.RWMutex
+	codings []string
+	m       map[string]Encoder
+}{
+	codings: []string{"br", "zstd", "gzip", "deflate"},
+	m: map[string]Encoder{
+		"br":      &brotliEncoder{level: brotliLevel},
+		"zstd":    &zstdEncoder{level: zstd.SpeedDefault},
+		"gzip":    &gzipEncoder{level: gzip.DefaultCompression},
+		"deflate": &deflateEncoder{level: zlib.DefaultCompression},
+	},
+}
+
+/*
+RegisterEncoder makes e available for responses with the content coding
+e.g., "br".  It replaces any Encoder already registered for coding.  A nil
+e removes coding.
+
+br, zstd, gzip and deflate are registered by default and are preferred in
+that order when the client accepts them equally.  Other codings are preferred
+in the order they are registered after the defaults.
+*/
+func RegisterEncoder(coding string, e Encoder) {
+	coding = strings.ToLower(coding)
+
+	encoders.Lock()
+	defer encoders.Unlock()
+
+	if e == nil {
+		delete(encoders.m, coding)
+		return
+	}
+
+	encoders.m[coding] = e
+
+	for _, c := range encoders.codings {
+		if c == coding {
+			return
+		}
+	}
+
+	encoders.codings = append(encoders.codings, coding)
+}
+
+// encoder returns the Encoder for the coding negotiated from the Accept-Encoding
+// header value.  Returns an empty coding and nil Encoder for identity.
+func encoder(acceptEncoding string) (string, Encoder) {
+	if acceptEncoding == "" {
+		return "", nil
+	}
+
+	q := parseQValues(acceptEncoding)
+
+	encoders.RLock()
+	defer encoders.RUnlock()
+
+	var coding string
+	var best float64
+
+	for _, c := range encoders.codings {
+		if _, ok := encoders.m[c]; !ok {
+			continue
+		}
+
+		v, ok := q[c]
+		if !ok {
+			v = q["*"]
+		}
+
+		if v > best {
+			coding = c
+			best = v
+		}
+	}
+
+	if coding == "" {
+		return "", nil
+	}
+
+	// identity is acceptable unless explicitly excluded.  Only use it instead of a coding
+	// when the client prefers it.
+	if v, ok := q["identity"]; ok && v > best {
+		return "", nil
+	}
+
+	return coding, encoders.m[coding]
+}
+
+/*
+parseQValues returns the q-value for each element in an Accept or Accept-Encoding
+header value s e.g., "gzip;q=1.0, identity; q=0.5, *;q=0".  Elements are lower case.
+Elements with an invalid q-value are ignored.
+*/
+func parseQValues(s string) map[string]float64 {
+	q := make(map[string]float64)
+
+	for _, e := range strings.Split(s, ",") {
+		p := strings.Split(e, ";")
+
+		c := strings.ToLower(strings.TrimSpace(p[0]))
+		if c == "" {
+			continue
+		}
+
+		v := 1.0
+		valid := true
+
+		for _, param := range p[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) < 2 || (param[0] != 'q' && param[0] != 'Q') || param[1] != '=' {
+				continue
+			}
+
+			f, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil || f < 0 || f > 1 {
+				valid = false
+				break
+			}
+			v = f
+		}
+
+		if valid {
+			q[c] = v
+		}
+	}
+
+	return q
+}
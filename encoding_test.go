@@ -0,0 +1,62 @@
+package weft
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseQValues(t *testing.T) {
+	q := parseQValues("gzip;q=1.0, identity; q=0.5, BR, deflate;q=2, *;q=0")
+
+	expected := map[string]float64{
+		"gzip":     1.0,
+		"identity": 0.5,
+		"br":       1.0,
+		"*":        0,
+	}
+
+	if len(q) != len(expected) {
+		t.Errorf("expected %d codings got %d", len(expected), len(q))
+	}
+
+	for k, v := range expected {
+		if q[k] != v {
+			t.Errorf("%s expected q=%f got %f", k, v, q[k])
+		}
+	}
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	RegisterEncoder("x-reverse", EncoderFunc(func(w io.Writer, p []byte) error {
+		for i := len(p) - 1; i >= 0; i-- {
+			if _, err := w.Write(p[i : i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	defer RegisterEncoder("x-reverse", nil)
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Accept-Encoding", "gzip;q=0.5, x-reverse")
+
+	var b bytes.Buffer
+	b.WriteString("bogan impsum bogan impsum")
+
+	w := httptest.NewRecorder()
+	WriteBytes(w, r, &Result{Code: http.StatusOK}, &b, false)
+
+	if w.Header().Get("Content-Encoding") != "x-reverse" {
+		t.Errorf("expected Content-Encoding x-reverse got %s", w.Header().Get("Content-Encoding"))
+	}
+
+	if w.Body.String() != "muspmi nagob muspmi nagob" {
+		t.Errorf("got wrong body %s", w.Body.String())
+	}
+}
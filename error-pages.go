@@ -1,6 +1,17 @@
 package weft
 
-import "net/http"
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
 
 const (
 	err404 = `<html>
@@ -57,7 +68,7 @@ const (
 	`
 	err400 = `<html>
 	<head>
-	<title>GeoNet - 404</title>
+	<title>GeoNet - 400</title>
 	<style>
 	body
 	{
@@ -107,7 +118,7 @@ const (
 
 	err405 = `<html>
 	<head>
-	<title>GeoNet - 404</title>
+	<title>GeoNet - 405</title>
 	<style>
 	body
 	{
@@ -155,6 +166,56 @@ const (
 	</html>
 	`
 
+	err500 = `<html>
+	<head>
+	<title>GeoNet - 500</title>
+	<style>
+	body
+	{
+		font: normal normal 14px/1.3 verdana,arial,helvetica,sans-serif;
+		color: #AEAEAE;
+	}
+	#container
+	{
+		margin: 10% auto;
+		width: 90%;
+		background: #EFEFEF;
+		border: #CCC solid 1px;
+		padding: 2em;
+	}
+	h1
+	{
+		font-size: 3em;
+		color: #AEAEAE;
+	}
+	p
+	{
+		color: #666;
+		text-shadow: #CCC .1em 0px .1em;
+	}
+	.corners-all
+	{
+		-webkit-border-radius: 5px;
+		-moz-border-radius: 5px;
+		border-radius: 5px;
+	}
+	</style>
+	</head>
+	<body>
+	<div id="container" class="corners-all">
+	<h1>Error 500</h1>
+
+	<p><b>500 Internal Server Error</b>: '500' is standard notation indicating that something went wrong at our end while handling your request.</p>
+
+	<p>It's always worth checking back in a few minutes time.  If you need more information about this error please contact us directly.</p>
+
+	<p>Many thanks for your patience,<br>
+	- The GeoNet Team.</p>
+	</div>
+	</body>
+	</html>
+	`
+
 	err503 = `<html>
 	<head>
 	<title>GeoNet 503</title>
@@ -198,12 +259,165 @@ const (
 	</div>
 	</body>
 	</html>`
+
+	err429 = `<html>
+	<head>
+	<title>GeoNet - 429</title>
+	<style>
+	body
+	{
+		font: normal normal 14px/1.3 verdana,arial,helvetica,sans-serif;
+		color: #AEAEAE;
+	}
+	#container
+	{
+		margin: 10% auto;
+		width: 90%;
+		background: #EFEFEF;
+		border: #CCC solid 1px;
+		padding: 2em;
+	}
+	h1
+	{
+		font-size: 3em;
+		color: #AEAEAE;
+	}
+	p
+	{
+		color: #666;
+		text-shadow: #CCC .1em 0px .1em;
+	}
+	.corners-all
+	{
+		-webkit-border-radius: 5px;
+		-moz-border-radius: 5px;
+		border-radius: 5px;
+	}	
+	</style>
+	</head>
+	<body>
+	<div id="container" class="corners-all">
+	<h1>Too Many Requests</h1>
+	<p>You have made too many requests to GeoNet in a short time.</p>
+	<p><b>Please wait a little before trying again.</b></p>
+	</div>
+	</body>
+	</html>`
 )
 
 var errorPages = map[int][]byte{
 	http.StatusNotFound:            []byte(err404),
 	http.StatusBadRequest:          []byte(err400),
 	http.StatusMethodNotAllowed:    []byte(err405),
-	http.StatusInternalServerError: []byte(err503),
+	http.StatusInternalServerError: []byte(err500),
 	http.StatusServiceUnavailable:  []byte(err503),
+	http.StatusTooManyRequests:     []byte(err429),
+}
+
+// ErrorPage is the data available to error page templates.
+type ErrorPage struct {
+	Code      int    // the HTTP status code e.g., 404.
+	Status    string // the HTTP status text e.g., "Not Found".
+	Msg       string // Result.Msg.
+	Path      string // the path for the request.
+	RequestID string // the ID for the request, see RequestID.  May be empty.
+}
+
+// errorTemplates are the registered error page templates.
+// The template for code 0 is used for codes with no template.
+var errorTemplates = struct {
+	sync.RWMutex
+	m map[int]*template.Template
+}{
+	m: make(map[int]*template.Template),
+}
+
+/*
+RegisterErrorPage registers t as the HTML error page template for the HTTP status code.
+t is executed with an ErrorPage.  Code 0 registers the template used for codes with no
+template of their own.  A nil t removes the template for code.
+
+The GeoNet error pages are used when no template is registered.
+*/
+func RegisterErrorPage(code int, t *template.Template) {
+	errorTemplates.Lock()
+	defer errorTemplates.Unlock()
+
+	if t == nil {
+		delete(errorTemplates.m, code)
+		return
+	}
+
+	errorTemplates.m[code] = t
+}
+
+/*
+LoadErrorPages registers error page templates from the files in fsys matching pattern.
+The base name of each file must be the status code it is for e.g., 404.html or
+"default" for the template used for codes with no template of their own e.g., default.html.
+fsys can be an embed.FS or from os.DirFS e.g.,
+
+	err := weft.LoadErrorPages(os.DirFS("assets/errors"), "*.html")
+*/
+func LoadErrorPages(fsys fs.FS, pattern string) error {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		n := path.Base(f)
+		n = strings.TrimSuffix(n, path.Ext(n))
+
+		var code int
+
+		if n != "default" {
+			if code, err = strconv.Atoi(n); err != nil {
+				return fmt.Errorf("error page %s is not named for a status code", f)
+			}
+		}
+
+		t, err := template.ParseFS(fsys, f)
+		if err != nil {
+			return err
+		}
+
+		RegisterErrorPage(code, t)
+	}
+
+	return nil
+}
+
+// writeErrorPage writes the HTML error page for res to b.
+func writeErrorPage(b *bytes.Buffer, r *http.Request, res *Result) {
+	errorTemplates.RLock()
+	t, ok := errorTemplates.m[res.Code]
+	if !ok {
+		t, ok = errorTemplates.m[0]
+	}
+	errorTemplates.RUnlock()
+
+	if ok {
+		p := ErrorPage{
+			Code:      res.Code,
+			Status:    http.StatusText(res.Code),
+			Msg:       res.Msg,
+			Path:      r.URL.Path,
+			RequestID: RequestID(r),
+		}
+
+		err := t.Execute(b, p)
+		if err == nil {
+			return
+		}
+
+		log.Printf("WARN: weft - error executing error page template for %d: %s", res.Code, err.Error())
+		b.Reset()
+	}
+
+	if e, ok := errorPages[res.Code]; ok {
+		b.Write(e)
+	} else {
+		b.Write(errorPages[http.StatusServiceUnavailable])
+	}
 }
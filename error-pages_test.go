@@ -0,0 +1,72 @@
+package weft
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRegisterErrorPage(t *testing.T) {
+	RegisterErrorPage(http.StatusNotFound, template.Must(template.New("404").Parse(
+		`<h1>{{.Code}} {{.Status}}</h1><p>{{.Msg}} {{.Path}} {{.RequestID}}</p>`)))
+	defer RegisterErrorPage(http.StatusNotFound, nil)
+
+	r, err := http.NewRequest("GET", "http://test.com/quake/<b>", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Request-ID", "abc123")
+
+	var b bytes.Buffer
+
+	w := httptest.NewRecorder()
+	WriteBytes(w, r, &Result{Code: http.StatusNotFound, Msg: "no quake"}, &b, true)
+	checkResponse(t, w, http.StatusNotFound, "max-age=10", "",
+		"<h1>404 Not Found</h1><p>no quake /quake/&lt;b&gt; abc123</p>")
+
+	// codes without a template use the GeoNet pages.
+	w = httptest.NewRecorder()
+	WriteBytes(w, r, &Result{Code: http.StatusBadRequest}, &b, true)
+	checkResponse(t, w, http.StatusBadRequest, "max-age=86400", "", err400)
+
+	// a broken template falls back to the GeoNet page.
+	RegisterErrorPage(http.StatusNotFound, template.Must(template.New("404").Parse(`{{.Missing}}`)))
+	w = httptest.NewRecorder()
+	WriteBytes(w, r, &Result{Code: http.StatusNotFound}, &b, true)
+	checkResponse(t, w, http.StatusNotFound, "max-age=10", "", err404)
+}
+
+func TestLoadErrorPages(t *testing.T) {
+	fsys := fstest.MapFS{
+		"errors/503.html":     {Data: []byte(`busy {{.Code}}`)},
+		"errors/default.html": {Data: []byte(`error {{.Code}}`)},
+	}
+
+	if err := LoadErrorPages(fsys, "errors/*.html"); err != nil {
+		t.Fatal(err)
+	}
+	defer RegisterErrorPage(http.StatusServiceUnavailable, nil)
+	defer RegisterErrorPage(0, nil)
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+
+	w := httptest.NewRecorder()
+	WriteBytes(w, r, &Result{Code: http.StatusServiceUnavailable}, &b, true)
+	checkResponse(t, w, http.StatusServiceUnavailable, "max-age=10", "", "busy 503")
+
+	w = httptest.NewRecorder()
+	WriteBytes(w, r, &Result{Code: http.StatusNotFound}, &b, true)
+	checkResponse(t, w, http.StatusNotFound, "max-age=10", "", "error 404")
+
+	if err := LoadErrorPages(fstest.MapFS{"oops.html": {Data: []byte(`oops`)}}, "*.html"); err == nil {
+		t.Error("expected error for file not named for a status code")
+	}
+}
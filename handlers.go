@@ -2,12 +2,11 @@ package weft
 
 import (
 	"bytes"
-	"compress/gzip"
-	"github.com/GeoNet/mtr/mtrapp"
 	"log"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 )
 
 var bufferPool = sync.Pool{
@@ -27,6 +26,7 @@ var compressibleMimes = map[string]bool{
 	"text/plain":                    true,
 	"text/xml":                      true,
 	"application/json":              true,
+	"application/problem+json":      true,
 	"application/vnd.ms-fontobject": true,
 	"application/x-font-opentype":   true,
 	"application/x-font-truetype":   true,
@@ -43,99 +43,178 @@ var compressibleMimes = map[string]bool{
 	"text/csv":                 true,
 }
 
-var surrogateControl = map[int]string{
-	http.StatusNotFound:            "max-age=10",
-	http.StatusServiceUnavailable:  "max-age=10",
-	http.StatusInternalServerError: "max-age=10",
-	http.StatusBadRequest:          "max-age=86400",
-	http.StatusMethodNotAllowed:    "max-age=86400",
-	http.StatusMovedPermanently:    "max-age=86400",
+// Option configures the handlers made by MakeHandlerPage and MakeHandlerAPI.
+type Option func(*handler)
+
+// handler serves a RequestHandler for MakeHandlerPage and MakeHandlerAPI.
+type handler struct {
+	f                 RequestHandler
+	name              string      // the name of f for metrics.
+	page              bool        // write HTML error pages.
+	errorFormat       ErrorFormat // the format for non HTML error bodies.
+	cache             *ResponseCache
+	cachePolicy       *CachePolicy  // nil for the package cachePolicy.
+	timeout           time.Duration // zero for no timeout.
+	latencyBudget     time.Duration // zero for the package latencyBudget.
+	requestIDInErrors bool
+	limit             *concurrencyLimit // nil for no limit.
+	middleware        []Middleware
 }
 
-/*
-MakeHandler executes f and writes the response in b to the client
-with gzipping and Surrogate-Control headers.
-
-HTML error pages are written to the client when res.Code is not http.StatusOK.
-*/
-func MakeHandlerPage(f RequestHandler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		t := mtrapp.Start()
+// policy returns the CachePolicy for h.
+func (h *handler) policy() *CachePolicy {
+	if h.cachePolicy != nil {
+		return h.cachePolicy
+	}
 
-		b := bufferPool.Get().(*bytes.Buffer)
-		defer bufferPool.Put(b)
-		b.Reset()
+	return cachePolicy
+}
 
-		res := f(r, w.Header(), b)
-		t.Stop()
+// newHandler returns a handler for f.  name is the name of the function
+// the caller was given, which may have been adapted to make f.
+func newHandler(f RequestHandler, name string, page bool, opts []Option) *handler {
+	h := &handler{
+		f:    f,
+		name: name,
+		page: page,
+	}
 
-		switch res.Code {
-		case http.StatusMovedPermanently:
-			w.Header().Set("Surrogate-Control", surrogateControl[http.StatusMovedPermanently])
-			http.Redirect(w, r, res.Redirect, http.StatusMovedPermanently)
-		case http.StatusSeeOther:
-			http.Redirect(w, r, res.Redirect, http.StatusSeeOther)
+	for _, o := range opts {
+		o(h)
+	}
 
-			// change the Code to 200 for adding to the metrics.
-			// 303 is a successful post followed by a GET redirect.
-			res.Code = http.StatusOK
-		default:
-			WriteBytes(w, r, res, b, true)
-		}
+	h.f = Chain(h.f, h.middleware...)
 
-		t.Track(name(f) + "." + r.Method)
-		res.Count()
+	return h
+}
 
-		res.log(r)
+/*
+MakeHandlerPage executes f and writes the response in b to the client
+with compression and Surrogate-Control headers.
 
-		if t.Taken() > 250 {
-			log.Printf("slow: took %d ms serving %s", t.Taken(), r.RequestURI)
-		}
-	}
+HTML error pages are written to the client when res.Code is not http.StatusOK.
+A panic in f is recovered and served as http.StatusInternalServerError, see SetPanicHandler.
+*/
+func MakeHandlerPage(f RequestHandler, opts ...Option) http.HandlerFunc {
+	return newHandler(f, name(f), true, opts).ServeHTTP
 }
 
 /*
 MakeHandlerAPI executes f.
 
 When res.Code is not http.StatusOK the contents of res.Msg are written to w.
+Use WithErrorFormat to write application/problem+json instead.
+A panic in f is recovered and served as http.StatusInternalServerError, see SetPanicHandler.
 
 Surrogate-Control headers are also set for intermediate caches.
 */
-func MakeHandlerAPI(f RequestHandler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		t := mtrapp.Start()
-		var res *Result
+func MakeHandlerAPI(f RequestHandler, opts ...Option) http.HandlerFunc {
+	return newHandler(f, name(f), false, opts).ServeHTTP
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	t := startMetrics(h.name, r.Method)
+
+	var handled time.Time
+	var res *Result
+	var cw *cacheWriter
+
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
+
+	r = withRequestID(w, r)
+
+	r, span := startServerSpan(r, h.name)
+
+	if h.cache != nil && r.Method == "GET" {
+		if h.cache.serve(w, r) {
+			res = &StatusOK
+			t.Stop()
+			handled = start
+		} else {
+			cw = &cacheWriter{ResponseWriter: w, before: w.Header().Clone()}
+			w = cw
+			r = withCacheWriter(r, cw)
+		}
+	}
 
+	if res == nil {
 		b := bufferPool.Get().(*bytes.Buffer)
-		defer bufferPool.Put(b)
 		b.Reset()
 
-		res = f(r, w.Header(), b)
+		res, b = h.call(r, w.Header(), b)
+		defer bufferPool.Put(b)
 		t.Stop()
-		WriteBytes(w, r, res, b, false)
-
-		t.Track(name(f) + "." + r.Method)
-		res.Count()
+		handled = time.Now()
 
-		res.log(r)
+		switch {
+		case h.page && res.Code == http.StatusMovedPermanently:
+			h.policy().apply(w.Header(), http.StatusMovedPermanently)
+			http.Redirect(w, r, res.Redirect, http.StatusMovedPermanently)
+		case h.page && res.Code == http.StatusSeeOther:
+			http.Redirect(w, r, res.Redirect, http.StatusSeeOther)
+		default:
+			h.writeBytes(w, r, res, b)
+		}
 
-		if t.Taken() > 250 {
-			log.Printf("slow: took %d ms serving %s", t.Taken(), r.RequestURI)
+		if cw != nil {
+			h.cache.store(r, cw)
 		}
 	}
+
+	code := sw.status(res.Code)
+
+	t.Done(code)
+	finishServerSpan(span, sw, res, code)
+
+	tm := Timing{
+		Handler: h.name,
+		Method:  r.Method,
+		Code:    code,
+		Handle:  handled.Sub(start),
+		Write:   time.Since(handled),
+		Budget:  h.budget(),
+	}
+
+	logRequest(newLogEntry(r, sw, res, tm))
+	tm.report(r)
 }
 
 /*
 MakeSimpleHandler executes f.  The caller should write directly to w for success (200) only.
 When res.Code is not http.StatusOK the contents of res.Msg are written to w.
 
-Responses are counted.  f is not wrapped with a timer as this includes the write to the client.
+Responses are counted, see SetMetrics.  f is not timed by MtrMetrics as this includes the write to the client.
+
+A panic in f is recovered and served as http.StatusInternalServerError, see SetPanicHandler.
+Anything f has already written to w can't be undone.
 */
 func MakeSimpleHandler(f SimpleRequestHandler) http.HandlerFunc {
+	n := name(f)
+
 	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		t := startMetrics(n, r.Method)
+
+		sw := &statusWriter{ResponseWriter: w}
+		w = sw
+
+		r = withRequestID(w, r)
+
+		r, span := startServerSpan(r, n)
+
 		var res *Result
 
-		res = f(r, w)
+		func() {
+			defer func() {
+				if v := recover(); v != nil {
+					res = recovered(r, v)
+				}
+			}()
+
+			res = f(r, w)
+		}()
 
 		// if we have StatusOK it means we've already written this to the header, so only handle other cases
 		if res.Code != http.StatusOK {
@@ -143,62 +222,96 @@ func MakeSimpleHandler(f SimpleRequestHandler) http.HandlerFunc {
 			Write(w, r, res)
 		}
 
-		res.Count()
-		res.log(r)
+		code := sw.status(res.Code)
+
+		t.Done(code)
+		finishServerSpan(span, sw, res, code)
+
+		tm := Timing{
+			Handler: n,
+			Method:  r.Method,
+			Code:    code,
+			Handle:  time.Since(start),
+			Budget:  latencyBudget,
+		}
+
+		logRequest(newLogEntry(r, sw, res, tm))
+		tm.report(r)
 	}
 }
 
 /*
 WriteBytes writes the contents of b to w.  Appropriate response headers are set.
-The response is gzipped if appropriate for the client and the content.
-Surrogate-Control headers are also set for intermediate caches.
-Surrogate-Control set calling WriteBytes will be respected for res.Code == http.StatusOK
-and overwritten for other Codes.
+The response is compressed if appropriate for the client and the content.
+The content coding is negotiated from the Accept-Encoding header and the
+Encoders available (see RegisterEncoder).
+Surrogate-Control and Cache-Control headers are also set for intermediate caches from
+the CachePolicy (see SetCachePolicy).  Headers set before calling WriteBytes will be
+respected for res.Code == http.StatusOK and overwritten for other Codes.
+
+For GET and HEAD requests with res.Code == http.StatusOK a strong ETag is generated
+from b unless one is set before calling WriteBytes.  http.StatusNotModified is written
+with no body when the If-None-Match or If-Modified-Since (with a Last-Modified set before
+calling WriteBytes) request headers match the response.  The ETag is made weak when
+the response is compressed.
+
+Range requests are served from b with http.StatusPartialContent (multipart/byteranges
+for multiple ranges) or http.StatusRequestedRangeNotSatisfiable.  Ranges are
+always over the identity (uncompressed) encoding.  An If-Range that does not
+strongly match the ETag or Last-Modified gets the full response.
 
 In the case of res.Code being for an error then HTML error pages or res.Msg is written
-to w depending on errorPage.
+to w depending on errorPage.  See RegisterErrorPage for changing the HTML error pages.
 
 If b is nil then only headers are written to w.
 */
 func WriteBytes(w http.ResponseWriter, r *http.Request, res *Result, b *bytes.Buffer, errorPage bool) {
+	h := handler{page: errorPage}
+	h.writeBytes(w, r, res, b)
+}
+
+// writeBytes implements WriteBytes using the error formats configured for h.
+func (h *handler) writeBytes(w http.ResponseWriter, r *http.Request, res *Result, b *bytes.Buffer) {
 	if res.Code == 0 {
 		res.Code = http.StatusOK
 		log.Printf("WARN: weft - received Result.Code == 0, serving 200.")
 	}
 
-	if w.Header().Get("Surrogate-Control") == "" {
-		w.Header().Set("Surrogate-Control", "max-age=10")
-	}
+	h.policy().apply(w.Header(), res.Code)
 
 	if res.Code != 200 {
-		switch errorPage {
-		case true:
+		switch {
+		case h.page:
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
 			if b != nil {
 				b.Reset()
-				if e, ok := errorPages[res.Code]; ok {
-					b.Write(e)
-				} else {
-					b.Write(errorPages[http.StatusInternalServerError])
+				writeErrorPage(b, r, res)
+			}
+		case h.errorFormat == ErrorProblemJSON:
+			w.Header().Add("Vary", "Accept")
+			if acceptsProblem(r) {
+				w.Header().Set("Content-Type", "application/problem+json")
+				if b != nil {
+					b.Reset()
+					writeProblem(b, r, res, h.requestIDInErrors)
 				}
+				break
 			}
-		case false:
+			fallthrough
+		default:
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			if b != nil {
 				b.Reset()
 				b.WriteString(res.Msg)
+				if id := RequestID(r); h.requestIDInErrors && id != "" {
+					b.WriteString(" (request id: " + id + ")")
+				}
 			}
 		}
-
-		if s, ok := surrogateControl[res.Code]; ok {
-			w.Header().Set("Surrogate-Control", s)
-		} else {
-			w.Header().Set("Surrogate-Control", "max-age=10")
-		}
 	}
 
 	/*
-	 write the response.  With gzipping if possible.
+	 write the response.  With compression if possible.
 	*/
 
 	w.Header().Add("Vary", "Accept-Encoding")
@@ -207,8 +320,23 @@ func WriteBytes(w http.ResponseWriter, r *http.Request, res *Result, b *bytes.Bu
 		w.Header().Set("Content-Type", http.DetectContentType(b.Bytes()))
 	}
 
-	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") && b != nil && b.Len() > 20 {
+	if res.Code == http.StatusOK && b != nil && b.Len() > 0 && (r.Method == "GET" || r.Method == "HEAD") {
+		if w.Header().Get("ETag") == "" {
+			w.Header().Set("ETag", etag(b.Bytes()))
+		}
+
+		if notModified(r, w.Header()) {
+			writeNotModified(w)
+			return
+		}
+
+		if r.Header.Get("Range") != "" && ifRange(r, w.Header()) {
+			serveRange(w, r, b.Bytes())
+			return
+		}
+	}
 
+	if b != nil && b.Len() > minCompressSize {
 		contentType := w.Header().Get("Content-Type")
 
 		i := strings.Index(contentType, ";")
@@ -219,16 +347,23 @@ func WriteBytes(w http.ResponseWriter, r *http.Request, res *Result, b *bytes.Bu
 		contentType = strings.TrimSpace(contentType)
 
 		if compressibleMimes[contentType] {
-			w.Header().Set("Content-Encoding", "gzip")
-			gz := gzip.NewWriter(w)
-			defer gz.Close()
-			w.WriteHeader(res.Code)
-			b.WriteTo(gz)
+			if coding, e := encoder(r.Header.Get("Accept-Encoding")); e != nil {
+				w.Header().Set("Content-Encoding", coding)
+				weakenETag(w.Header())
+				w.WriteHeader(res.Code)
+				if err := e.Encode(w, b.Bytes()); err != nil {
+					log.Printf("WARN: weft - error writing %s response: %s", coding, err.Error())
+				}
 
-			return
+				return
+			}
 		}
 	}
 
+	if res.Code == http.StatusOK && b != nil && b.Len() > 0 && (r.Method == "GET" || r.Method == "HEAD") {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
 	w.WriteHeader(res.Code)
 	if b != nil {
 		b.WriteTo(w)
@@ -239,9 +374,9 @@ func WriteBytes(w http.ResponseWriter, r *http.Request, res *Result, b *bytes.Bu
 Write writes a header response to the client and in the case of
 res.Code != http.StatusOK also writes res.Msg.
 
-Surrogate-Control headers are also set for intermediate caches.
-Surrogate-Control set calling Write will be respected for
-res.Code == http.StatusOK and overwritten for other Codes.
+Surrogate-Control and Cache-Control headers are also set for intermediate caches from
+the CachePolicy (see SetCachePolicy).  Headers set before calling Write will be respected
+for res.Code == http.StatusOK and overwritten for other Codes.
 */
 func Write(w http.ResponseWriter, r *http.Request, res *Result) {
 	if res.Code == 0 {
@@ -249,21 +384,11 @@ func Write(w http.ResponseWriter, r *http.Request, res *Result) {
 		log.Printf("WARN: weft - received Result.Code == 0, serving 200.")
 	}
 
-	switch res.Code {
-	case http.StatusOK:
-		if w.Header().Get("Surrogate-Control") == "" {
-			w.Header().Set("Surrogate-Control", "max-age=10")
-		}
+	cachePolicy.apply(w.Header(), res.Code)
 
-		w.WriteHeader(res.Code)
-	default:
-		if s, ok := surrogateControl[res.Code]; ok {
-			w.Header().Set("Surrogate-Control", s)
-		} else {
-			w.Header().Set("Surrogate-Control", "max-age=10")
-		}
+	w.WriteHeader(res.Code)
 
-		w.WriteHeader(res.Code)
+	if res.Code != http.StatusOK {
 		w.Write([]byte(res.Msg))
 	}
 }
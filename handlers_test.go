@@ -3,11 +3,16 @@ package weft
 import (
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"runtime"
 	"strconv"
 	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
 /*
@@ -109,6 +114,55 @@ func TestWriteGzip(t *testing.T) {
 	}
 }
 
+/*
+TestWriteAcceptEncoding checks the content coding is negotiated from
+the Accept-Encoding q-values.
+*/
+func TestWriteAcceptEncoding(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := Result{Code: http.StatusOK}
+	var b bytes.Buffer
+
+	in := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{acceptEncoding: "gzip;q=0", encoding: ""},
+		{acceptEncoding: "gzip;q=0, deflate", encoding: "deflate"},
+		{acceptEncoding: "deflate;q=0.5, gzip;q=0.4", encoding: "deflate"},
+		{acceptEncoding: "deflate, gzip", encoding: "gzip"},
+		{acceptEncoding: "*", encoding: "br"},
+		{acceptEncoding: "*;q=0", encoding: ""},
+		{acceptEncoding: "identity", encoding: ""},
+		{acceptEncoding: "gzip;q=0.5, identity", encoding: ""},
+		{acceptEncoding: "br, zstd", encoding: "br"},
+		{acceptEncoding: "br;q=0.5, zstd", encoding: "zstd"},
+		{acceptEncoding: "gzip, zstd;q=0.5", encoding: "gzip"},
+	}
+
+	for i, v := range in {
+		b.Reset()
+		b.WriteString("bogan impsum bogan impsum")
+		b.WriteString("bogan impsum bogan impsum")
+		e := b.String()
+
+		w := httptest.NewRecorder()
+		r.Header.Set("Accept-Encoding", v.acceptEncoding)
+		WriteBytes(w, r, &res, &b, false)
+
+		if w.Header().Get("Content-Encoding") != v.encoding {
+			t.Errorf("%d %s expected Content-Encoding %s got %s", i, v.acceptEncoding, v.encoding, w.Header().Get("Content-Encoding"))
+			continue
+		}
+
+		checkResponse(t, w, res.Code, "max-age=10", v.encoding, e)
+	}
+}
+
 func TestWritePage(t *testing.T) {
 	var w *httptest.ResponseRecorder
 
@@ -145,7 +199,7 @@ func TestWritePage(t *testing.T) {
 	w = httptest.NewRecorder()
 	res.Code = http.StatusInternalServerError
 	WriteBytes(w, r, &res, &b, true)
-	checkResponse(t, w, res.Code, "max-age=10", "", err503)
+	checkResponse(t, w, res.Code, "max-age=10", "", err500)
 
 	w = httptest.NewRecorder()
 	res.Code = http.StatusServiceUnavailable
@@ -287,6 +341,39 @@ func checkResponse(t *testing.T, w *httptest.ResponseRecorder, code int, surroga
 		var b bytes.Buffer
 		b.ReadFrom(gz)
 
+		if b.String() != body {
+			t.Errorf("%s got wrong body", l)
+		}
+	case "deflate":
+		z, err := zlib.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer z.Close()
+
+		var b bytes.Buffer
+		b.ReadFrom(z)
+
+		if b.String() != body {
+			t.Errorf("%s got wrong body", l)
+		}
+	case "br":
+		var b bytes.Buffer
+		b.ReadFrom(brotli.NewReader(w.Body))
+
+		if b.String() != body {
+			t.Errorf("%s got wrong body", l)
+		}
+	case "zstd":
+		z, err := zstd.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer z.Close()
+
+		var b bytes.Buffer
+		b.ReadFrom(z)
+
 		if b.String() != body {
 			t.Errorf("%s got wrong body", l)
 		}
@@ -302,3 +389,60 @@ func loc() (loc string) {
 	_, _, l, _ := runtime.Caller(2)
 	return "L" + strconv.Itoa(l)
 }
+
+/*
+Benchmarks for pooling gzip writers in WriteBytes.  Unpooled allocates
+a new gzip.Writer for every response.
+
+	go test -bench=WriteBytesGzip -benchmem
+	BenchmarkWriteBytesGzip           	   85069	     13932 ns/op	    1392 B/op	      16 allocs/op
+	BenchmarkWriteBytesGzipUnpooled   	    5690	    216382 ns/op	 1077392 B/op	      30 allocs/op
+*/
+func BenchmarkWriteBytesGzip(b *testing.B) {
+	benchmarkWriteBytes(b, "gzip")
+}
+
+func BenchmarkWriteBytesGzipUnpooled(b *testing.B) {
+	RegisterEncoder("gzip", EncoderFunc(func(w io.Writer, p []byte) error {
+		gz := gzip.NewWriter(w)
+		if _, err := gz.Write(p); err != nil {
+			return err
+		}
+		return gz.Close()
+	}))
+	defer RegisterEncoder("gzip", &gzipEncoder{level: gzip.DefaultCompression})
+
+	benchmarkWriteBytes(b, "gzip")
+}
+
+func benchmarkWriteBytes(b *testing.B, encoding string) {
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r.Header.Set("Accept-Encoding", encoding)
+
+	var body bytes.Buffer
+	for i := 0; i < 100; i++ {
+		body.WriteString("bogan impsum bogan impsum")
+	}
+
+	var buf bytes.Buffer
+	res := Result{Code: http.StatusOK}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		buf.Reset()
+		buf.Write(body.Bytes())
+
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "text/plain")
+		WriteBytes(w, r, &res, &buf, false)
+
+		if w.Header().Get("Content-Encoding") != encoding {
+			b.Fatalf("expected Content-Encoding %s", encoding)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package weft
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const hmacScheme = "HMAC-SHA256"
+
+// DefaultHMACMaxBytes is the default limit on the request body HMACAuth reads to check the signature.
+const DefaultHMACMaxBytes = 1 << 20
+
+/*
+HMACAuth authenticates requests signed with a secret shared with the client e.g.,
+
+	Authorization: HMAC-SHA256 keyId="sensor-1", signature="base64 signature"
+
+The signature is the HMAC-SHA256 with the secret for keyId over the string
+
+	method + "\n" + request URI + "\n" + Date header + "\n" + hex(SHA-256(body))
+
+The Date header must be within the allowed clock skew of the server.  Clients can use SignRequest.
+
+The body is read into memory to check the signature.  Requests with bodies larger than
+DefaultHMACMaxBytes, or the limit set with SetMaxBytes, have invalid credentials.
+*/
+type HMACAuth struct {
+	realm   string
+	maxSkew time.Duration
+
+	mu       sync.RWMutex
+	keys     map[string]hmacKey
+	maxBytes int64
+}
+
+type hmacKey struct {
+	secret []byte
+	p      Principal
+}
+
+// NewHMACAuth returns an HMACAuth for realm allowing maxSkew between the request Date and the server clock.
+func NewHMACAuth(realm string, maxSkew time.Duration) *HMACAuth {
+	return &HMACAuth{
+		realm:    realm,
+		maxSkew:  maxSkew,
+		keys:     make(map[string]hmacKey),
+		maxBytes: DefaultHMACMaxBytes,
+	}
+}
+
+// SetMaxBytes sets the limit on the request body read to check the signature.
+func (a *HMACAuth) SetMaxBytes(n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.maxBytes = n
+}
+
+// AddKey adds the secret with id for the Principal p.
+func (a *HMACAuth) AddKey(id string, secret []byte, p Principal) {
+	p.Scheme = hmacScheme
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.keys[id] = hmacKey{secret: secret, p: p}
+}
+
+func (a *HMACAuth) Authenticate(r *http.Request) (*Principal, error) {
+	s, ok := authScheme(r, hmacScheme)
+	if !ok {
+		return nil, nil
+	}
+
+	params := parseAuthParams(s)
+
+	a.mu.RLock()
+	k, ok := a.keys[params["keyid"]]
+	maxBytes := a.maxBytes
+	a.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	d, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if skew := time.Since(d); skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, ErrInvalidCredentials
+	}
+
+	mac, err := hmacSignature(r, k.secret, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(sig, mac) {
+		return nil, ErrInvalidCredentials
+	}
+
+	p := k.p
+	p.Name = params["keyid"]
+
+	return &p, nil
+}
+
+func (a *HMACAuth) Challenge() string {
+	return hmacScheme + ` realm=` + quoteAuthParam(a.realm)
+}
+
+/*
+SignRequest signs r for HMACAuth with the secret for keyID.  The Date header is set
+if it is not already.  The body of r is read and replaced so r can still be sent.
+*/
+func SignRequest(r *http.Request, keyID string, secret []byte) error {
+	if r.Header.Get("Date") == "" {
+		r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	mac, err := hmacSignature(r, secret, 0)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Authorization", hmacScheme+` keyId=`+quoteAuthParam(keyID)+`, signature="`+base64.StdEncoding.EncodeToString(mac)+`"`)
+
+	return nil
+}
+
+// hmacSignature returns the HMAC-SHA256 signature for r.  The body of r is read and replaced.
+// Bodies larger than maxBytes are ErrInvalidCredentials.  Zero means no limit.
+func hmacSignature(r *http.Request, secret []byte, maxBytes int64) ([]byte, error) {
+	body := sha256.New()
+
+	if r.Body != nil && r.Body != http.NoBody {
+		if maxBytes > 0 && r.ContentLength > maxBytes {
+			return nil, ErrInvalidCredentials
+		}
+
+		var src io.Reader = r.Body
+		if maxBytes > 0 {
+			// read one more than allowed to find bodies that are too large.
+			src = io.LimitReader(r.Body, maxBytes+1)
+		}
+
+		var b bytes.Buffer
+		if _, err := io.Copy(io.MultiWriter(&b, body), src); err != nil {
+			return nil, err
+		}
+
+		if maxBytes > 0 && int64(b.Len()) > maxBytes {
+			return nil, ErrInvalidCredentials
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(&b)
+	}
+
+	m := hmac.New(sha256.New, secret)
+	io.WriteString(m, r.Method+"\n"+r.URL.RequestURI()+"\n"+r.Header.Get("Date")+"\n"+hex.EncodeToString(body.Sum(nil)))
+
+	return m.Sum(nil), nil
+}
+
+// parseAuthParams parses comma separated auth-params e.g., `keyId="a", signature="b"`.
+// Keys are lower case.
+func parseAuthParams(s string) map[string]string {
+	p := make(map[string]string)
+
+	for _, v := range strings.Split(s, ",") {
+		i := strings.Index(v, "=")
+		if i < 0 {
+			continue
+		}
+
+		k := strings.ToLower(strings.TrimSpace(v[:i]))
+		v = strings.TrimSpace(v[i+1:])
+
+		if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+			v = strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(v[1 : len(v)-1])
+		}
+
+		p[k] = v
+	}
+
+	return p
+}
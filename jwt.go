@@ -0,0 +1,356 @@
+package weft
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // for crypto.SHA256
+	_ "crypto/sha512" // for crypto.SHA384 and crypto.SHA512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtLeeway is the clock skew allowed when checking exp and nbf.
+const jwtLeeway = time.Minute
+
+/*
+JWTAuth authenticates JWT bearer tokens signed with keys from a local JSON Web Key Set.
+RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512, HS256, HS384 and HS512 are supported.
+
+The exp and nbf claims are checked when present.  The iss and aud claims are checked when
+an issuer or audience are given to NewJWTAuth.  The Principal Name is the sub claim and
+the Roles are from the roles claim (an array of strings) or the scope claim (space separated).
+
+Bearer tokens that are not JWTs are ignored so JWTAuth can be used with BearerAuth, see Authenticators.
+*/
+type JWTAuth struct {
+	realm    string
+	issuer   string
+	audience string
+
+	mu   sync.RWMutex
+	keys []jwk
+}
+
+type jwk struct {
+	kid string
+	alg string
+	key interface{} // *rsa.PublicKey, *ecdsa.PublicKey or []byte.
+}
+
+// NewJWTAuth returns a JWTAuth for realm with no keys.  Empty issuer or audience are not checked.
+func NewJWTAuth(realm, issuer, audience string) *JWTAuth {
+	return &JWTAuth{
+		realm:    realm,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+// ReadJWKS replaces the keys for a with the JSON Web Key Set (RFC 7517) read from r.
+// Keys with a use other than "sig" are ignored.
+func (a *JWTAuth) ReadJWKS(r io.Reader) error {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return err
+	}
+
+	var keys []jwk
+
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		j := jwk{kid: k.Kid, alg: k.Alg}
+
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return fmt.Errorf("jwks key %d: n: %s", i, err.Error())
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil || !e.IsInt64() {
+				return fmt.Errorf("jwks key %d: invalid e", i)
+			}
+			j.key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var c elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				c = elliptic.P256()
+			case "P-384":
+				c = elliptic.P384()
+			case "P-521":
+				c = elliptic.P521()
+			default:
+				return fmt.Errorf("jwks key %d: unsupported curve %s", i, k.Crv)
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return fmt.Errorf("jwks key %d: x: %s", i, err.Error())
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return fmt.Errorf("jwks key %d: y: %s", i, err.Error())
+			}
+			if !c.IsOnCurve(x, y) {
+				return fmt.Errorf("jwks key %d: point not on curve %s", i, k.Crv)
+			}
+			j.key = &ecdsa.PublicKey{Curve: c, X: x, Y: y}
+		case "oct":
+			b, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(b) == 0 {
+				return fmt.Errorf("jwks key %d: invalid k", i)
+			}
+			j.key = b
+		default:
+			return fmt.Errorf("jwks key %d: unsupported kty %s", i, k.Kty)
+		}
+
+		keys = append(keys, j)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.keys = keys
+
+	return nil
+}
+
+// ReadJWKSFile replaces the keys for a with the JSON Web Key Set in the file name.
+func (a *JWTAuth) ReadJWKSFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return a.ReadJWKS(f)
+}
+
+func (a *JWTAuth) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+
+	claims, err := a.verify(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Principal{Scheme: "Bearer", Claims: claims}
+	p.Name, _ = claims["sub"].(string)
+
+	switch roles := claims["roles"].(type) {
+	case []interface{}:
+		for _, v := range roles {
+			if s, ok := v.(string); ok {
+				p.Roles = append(p.Roles, s)
+			}
+		}
+	default:
+		if s, ok := claims["scope"].(string); ok {
+			p.Roles = strings.Fields(s)
+		}
+	}
+
+	return p, nil
+}
+
+func (a *JWTAuth) Challenge() string {
+	return `Bearer realm=` + quoteAuthParam(a.realm)
+}
+
+var errJWT = fmt.Errorf("%w: invalid token", ErrInvalidCredentials)
+
+// verify checks the signature and claims of token at now.  Returns the claims.
+func (a *JWTAuth) verify(token string, now time.Time) (map[string]interface{}, error) {
+	p := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err := decodeJWTPart(p[0], &header); err != nil {
+		return nil, errJWT
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(p[2])
+	if err != nil {
+		return nil, errJWT
+	}
+
+	a.mu.RLock()
+	keys := a.keys
+	a.mu.RUnlock()
+
+	var verified bool
+
+	for _, k := range keys {
+		if (header.Kid != "" && k.kid != header.Kid) || (k.alg != "" && k.alg != header.Alg) {
+			continue
+		}
+
+		if verifyJWS(header.Alg, k.key, []byte(p[0]+"."+p[1]), sig) {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, errJWT
+	}
+
+	var claims map[string]interface{}
+
+	if err := decodeJWTPart(p[1], &claims); err != nil {
+		return nil, errJWT
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errJWT
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-jwtLeeway)) {
+		return nil, errJWT
+	}
+
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return nil, errJWT
+	}
+
+	if a.audience != "" {
+		var ok bool
+
+		switch aud := claims["aud"].(type) {
+		case string:
+			ok = aud == a.audience
+		case []interface{}:
+			for _, v := range aud {
+				if v == a.audience {
+					ok = true
+					break
+				}
+			}
+		}
+
+		if !ok {
+			return nil, errJWT
+		}
+	}
+
+	return claims, nil
+}
+
+// verifyJWS returns true if sig is a valid signature for alg of signed with key.
+func verifyJWS(alg string, key interface{}, signed, sig []byte) bool {
+	if len(alg) != 5 {
+		return false
+	}
+
+	var h crypto.Hash
+
+	switch alg[2:] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	default:
+		return false
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		d := h.New()
+		d.Write(signed)
+
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, h, d.Sum(nil), sig) == nil
+		case "PS":
+			return rsa.VerifyPSS(k, h, d.Sum(nil), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			return false
+		}
+
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+
+		d := h.New()
+		d.Write(signed)
+
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+
+		return ecdsa.Verify(k, d.Sum(nil), r, s)
+	case []byte:
+		if alg[:2] != "HS" {
+			return false
+		}
+
+		m := hmac.New(h.New, k)
+		m.Write(signed)
+
+		return hmac.Equal(m.Sum(nil), sig)
+	}
+
+	return false
+}
+
+func decodeJWTPart(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,168 @@
+package weft
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signJWT returns a JWT for claims signed with key.
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	h, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := b64(h) + "." + b64(c)
+	d := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, d[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, d[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case []byte:
+		m := hmac.New(sha256.New, k)
+		m.Write([]byte(signed))
+		sig = m.Sum(nil)
+	}
+
+	return signed + "." + b64(sig)
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "alg": "RS256", "n": "%s", "e": "%s"},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": "%s", "y": "%s"},
+		{"kty": "oct", "kid": "hs", "alg": "HS256", "k": "%s"},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "%s", "e": "AQAB"}
+	]}`,
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		b64(ecKey.X.Bytes()), b64(ecKey.Y.Bytes()),
+		b64(secret),
+		b64(rsaKey.N.Bytes()))
+
+	a := NewJWTAuth("weft", "https://auth.geonet.org.nz", "weft")
+	if err := a.ReadJWKS(strings.NewReader(jwks)); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+
+	claims := func(m map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   "https://auth.geonet.org.nz",
+			"aud":   []string{"other", "weft"},
+			"sub":   "alice",
+			"exp":   now + 60,
+			"nbf":   now - 60,
+			"roles": []string{"admin"},
+		}
+		for k, v := range m {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	in := []struct {
+		token string
+		valid bool
+		roles []string
+	}{
+		{token: signJWT(t, "RS256", "rsa", rsaKey, claims(nil)), valid: true, roles: []string{"admin"}},
+		{token: signJWT(t, "ES256", "ec", ecKey, claims(nil)), valid: true, roles: []string{"admin"}},
+		{token: signJWT(t, "HS256", "hs", secret, claims(map[string]interface{}{"roles": nil, "scope": "read write"})), valid: true, roles: []string{"read", "write"}},
+		{token: signJWT(t, "RS256", "", rsaKey, claims(map[string]interface{}{"aud": "weft"})), valid: true, roles: []string{"admin"}},
+		{token: signJWT(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": now - 3600}))},
+		{token: signJWT(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"nbf": now + 3600}))},
+		{token: signJWT(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"iss": "https://evil.com"}))},
+		{token: signJWT(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"}))},
+		{token: signJWT(t, "RS256", "enc", rsaKey, claims(nil))},
+		// the RSA public key used as an HMAC secret.
+		{token: signJWT(t, "HS256", "rsa", rsaKey.N.Bytes(), claims(nil))},
+		{token: signJWT(t, "none", "rsa", nil, claims(nil))},
+		{token: signJWT(t, "RS256", "rsa", rsaKey, claims(nil))[:40] + "x.y.z"},
+	}
+
+	for i, v := range in {
+		r := httptest.NewRequest("GET", "/quake", nil)
+		r.Header.Set("Authorization", "Bearer "+v.token)
+
+		p, err := a.Authenticate(r)
+
+		if !v.valid {
+			if err == nil || p != nil {
+				t.Errorf("%d expected invalid token", i)
+			}
+			continue
+		}
+
+		if err != nil || p == nil {
+			t.Errorf("%d expected valid token got %v", i, err)
+			continue
+		}
+
+		if p.Name != "alice" || p.Claims["iss"] != "https://auth.geonet.org.nz" || strings.Join(p.Roles, ",") != strings.Join(v.roles, ",") {
+			t.Errorf("%d unexpected principal %+v", i, p)
+		}
+	}
+
+	// opaque bearer tokens are left for other Authenticators.
+	r := httptest.NewRequest("GET", "/quake", nil)
+	r.Header.Set("Authorization", "Bearer abc123")
+
+	if p, err := a.Authenticate(r); p != nil || err != nil {
+		t.Error("expected opaque token to be ignored")
+	}
+
+	if err := a.ReadJWKS(strings.NewReader(`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`)); err == nil {
+		t.Error("expected error for point not on curve")
+	}
+}
@@ -0,0 +1,326 @@
+package weft
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of a LogEntry.
+type Level int
+
+const (
+	LevelInfo  Level = iota // successful and redirected requests.
+	LevelWarn               // client errors (4xx).
+	LevelError              // server errors (5xx).
+	LevelOff                // set with SetLogLevel to disable the access log.
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelOff:
+		return "off"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// levelFor returns the Level for a response with the status code.
+func levelFor(code int) Level {
+	switch {
+	case code >= 500:
+		return LevelError
+	case code >= 400:
+		return LevelWarn
+	default:
+		return LevelInfo
+	}
+}
+
+// LogEntry is the access log entry for a request served by a weft handler.
+type LogEntry struct {
+	Time       time.Time
+	Level      Level
+	Handler    string        // the handler name.
+	Method     string        // the request method.
+	Path       string        // the request URL path.
+	Query      string        // the raw request URL query.
+	Status     int           // the status code written to the client.
+	Bytes      int64         // the number of body bytes written to the client.
+	Duration   time.Duration // the total time serving the request.
+	Encoding   string        // the response Content-Encoding.
+	RemoteAddr string
+	RequestID  string
+	Msg        string // the Result.Msg.
+}
+
+// Logger writes access log entries.  Implementations must be safe for concurrent use.
+type Logger interface {
+	Log(e *LogEntry)
+}
+
+// LoggerFunc is an adapter to allow the use of ordinary functions as a Logger.
+type LoggerFunc func(e *LogEntry)
+
+func (f LoggerFunc) Log(e *LogEntry) {
+	f(e)
+}
+
+// LogFormat is the output format for NewLogger.
+type LogFormat int
+
+const (
+	LogText   LogFormat = iota // a human readable line.
+	LogJSON                    // one JSON object per line.
+	LogLogfmt                  // key=value pairs.
+)
+
+var logger = struct {
+	sync.RWMutex
+	l     Logger
+	level Level
+}{
+	l:     NewLogger(nil, LogText),
+	level: LevelWarn,
+}
+
+/*
+SetLogger sets l to write the access log for handlers made with MakeHandlerPage, MakeHandlerAPI
+and MakeSimpleHandler.  A nil l restores the default, which writes LogText to the standard
+logger from the log package.
+*/
+func SetLogger(l Logger) {
+	if l == nil {
+		l = NewLogger(nil, LogText)
+	}
+
+	logger.Lock()
+	defer logger.Unlock()
+
+	logger.l = l
+}
+
+/*
+SetLogLevel sets the minimum Level for requests to be written to the access log.
+The default is LevelWarn, which logs requests with errors.  LevelInfo logs every request.
+LevelOff disables the access log.
+*/
+func SetLogLevel(l Level) {
+	logger.Lock()
+	defer logger.Unlock()
+
+	logger.level = l
+}
+
+// logRequest writes e to the access log if it is at or above the log level.
+func logRequest(e *LogEntry) {
+	logger.RLock()
+	l, level := logger.l, logger.level
+	logger.RUnlock()
+
+	if e.Level < level {
+		return
+	}
+
+	l.Log(e)
+}
+
+// newLogEntry returns the LogEntry for r served with res.
+func newLogEntry(r *http.Request, w *statusWriter, res *Result, t Timing) *LogEntry {
+	return &LogEntry{
+		Time:       time.Now().UTC(),
+		Level:      levelFor(t.Code),
+		Handler:    t.Handler,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		Status:     t.Code,
+		Bytes:      w.n,
+		Duration:   t.Total(),
+		Encoding:   w.Header().Get("Content-Encoding"),
+		RemoteAddr: r.RemoteAddr,
+		RequestID:  RequestID(r),
+		Msg:        res.Msg,
+	}
+}
+
+/*
+NewLogger returns a Logger that writes entries to w in format.  If w is nil entries are
+written with the standard logger from the log package, which adds its own prefix and time.
+*/
+func NewLogger(w io.Writer, format LogFormat) Logger {
+	return &writerLogger{w: w, format: format}
+}
+
+type writerLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format LogFormat
+}
+
+func (l *writerLogger) Log(e *LogEntry) {
+	var b bytes.Buffer
+
+	switch l.format {
+	case LogJSON:
+		e.writeJSON(&b)
+	case LogLogfmt:
+		e.writeLogfmt(&b, l.w != nil)
+	default:
+		e.writeText(&b, l.w != nil)
+	}
+
+	if l.w == nil {
+		log.Print(b.String())
+		return
+	}
+
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.w.Write(b.Bytes())
+}
+
+// uri returns the request path and query.
+func (e *LogEntry) uri() string {
+	if e.Query == "" {
+		return e.Path
+	}
+	return e.Path + "?" + e.Query
+}
+
+// writeText writes e as a line e.g., "warn: 404 GET /path 3 ms 9 bytes ..."
+func (e *LogEntry) writeText(b *bytes.Buffer, withTime bool) {
+	if withTime {
+		b.WriteString(e.Time.Format(time.RFC3339Nano))
+		b.WriteByte(' ')
+	}
+
+	fmt.Fprintf(b, "%s: %d %s %s %s %d bytes", e.Level, e.Status, escapeText(e.Method), escapeText(e.uri()), e.Duration, e.Bytes)
+
+	if e.Encoding != "" {
+		b.WriteString(" " + escapeText(e.Encoding))
+	}
+
+	b.WriteString(" from " + escapeText(e.RemoteAddr))
+
+	if e.RequestID != "" {
+		b.WriteString(" request " + escapeText(e.RequestID))
+	}
+
+	if e.Msg != "" {
+		b.WriteString(" msg: " + escapeText(e.Msg))
+	}
+}
+
+// escapeText returns s with control characters escaped e.g., "\n" so that
+// client supplied values can't start a new log line.
+func escapeText(s string) string {
+	if strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+
+	var b strings.Builder
+
+	for _, r := range s {
+		if !unicode.IsControl(r) {
+			b.WriteRune(r)
+			continue
+		}
+
+		q := strconv.QuoteRune(r)
+		b.WriteString(q[1 : len(q)-1])
+	}
+
+	return b.String()
+}
+
+func (e *LogEntry) writeJSON(b *bytes.Buffer) {
+	j := struct {
+		Time       string  `json:"time"`
+		Level      string  `json:"level"`
+		Handler    string  `json:"handler"`
+		Method     string  `json:"method"`
+		Path       string  `json:"path"`
+		Query      string  `json:"query,omitempty"`
+		Status     int     `json:"status"`
+		Bytes      int64   `json:"bytes"`
+		Duration   float64 `json:"duration_ms"`
+		Encoding   string  `json:"encoding,omitempty"`
+		RemoteAddr string  `json:"remote_addr"`
+		RequestID  string  `json:"request_id,omitempty"`
+		Msg        string  `json:"msg,omitempty"`
+	}{
+		Time:       e.Time.Format(time.RFC3339Nano),
+		Level:      e.Level.String(),
+		Handler:    e.Handler,
+		Method:     e.Method,
+		Path:       e.Path,
+		Query:      e.Query,
+		Status:     e.Status,
+		Bytes:      e.Bytes,
+		Duration:   e.Duration.Seconds() * 1000,
+		Encoding:   e.Encoding,
+		RemoteAddr: e.RemoteAddr,
+		RequestID:  e.RequestID,
+		Msg:        e.Msg,
+	}
+
+	// the fields are strings and numbers so this won't error.
+	p, _ := json.Marshal(j)
+	b.Write(p)
+}
+
+func (e *LogEntry) writeLogfmt(b *bytes.Buffer, withTime bool) {
+	if withTime {
+		b.WriteString("time=" + e.Time.Format(time.RFC3339Nano) + " ")
+	}
+
+	b.WriteString("level=" + e.Level.String())
+	writeLogfmtPair(b, "handler", e.Handler)
+	writeLogfmtPair(b, "method", e.Method)
+	writeLogfmtPair(b, "path", e.Path)
+	if e.Query != "" {
+		writeLogfmtPair(b, "query", e.Query)
+	}
+	writeLogfmtPair(b, "status", strconv.Itoa(e.Status))
+	writeLogfmtPair(b, "bytes", strconv.FormatInt(e.Bytes, 10))
+	writeLogfmtPair(b, "duration", e.Duration.String())
+	if e.Encoding != "" {
+		writeLogfmtPair(b, "encoding", e.Encoding)
+	}
+	writeLogfmtPair(b, "remote_addr", e.RemoteAddr)
+	if e.RequestID != "" {
+		writeLogfmtPair(b, "request_id", e.RequestID)
+	}
+	if e.Msg != "" {
+		writeLogfmtPair(b, "msg", e.Msg)
+	}
+}
+
+// writeLogfmtPair writes " k=v" to b quoting v if needed.
+func writeLogfmtPair(b *bytes.Buffer, k, v string) {
+	b.WriteString(" " + k + "=")
+
+	if v == "" || strings.ContainsAny(v, " =\"\\") || strings.IndexFunc(v, unicode.IsControl) >= 0 {
+		b.WriteString(strconv.Quote(v))
+		return
+	}
+
+	b.WriteString(v)
+}
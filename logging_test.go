@@ -0,0 +1,141 @@
+package weft
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogLevel(t *testing.T) {
+	var entries []*LogEntry
+
+	SetLogger(LoggerFunc(func(e *LogEntry) {
+		entries = append(entries, e)
+	}))
+	defer SetLogger(nil)
+	defer SetLogLevel(LevelWarn)
+
+	ok := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString(strings.Repeat("GeoNet ", 10))
+		return &StatusOK
+	})
+
+	notFound := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return &NotFound
+	})
+
+	serve := func() {
+		r := httptest.NewRequest("GET", "/path?q=1", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		ok(httptest.NewRecorder(), r)
+		notFound(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	}
+
+	serve()
+
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry at LevelWarn got %d", len(entries))
+	}
+
+	e := entries[0]
+	if e.Level != LevelWarn || e.Status != http.StatusNotFound || e.Path != "/missing" || e.Msg != "not found" || e.Bytes != 9 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	entries = nil
+	SetLogLevel(LevelInfo)
+	serve()
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries at LevelInfo got %d", len(entries))
+	}
+
+	e = entries[0]
+	if e.Level != LevelInfo || e.Status != http.StatusOK || e.Query != "q=1" || e.Encoding != "gzip" || e.Bytes == 0 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	entries = nil
+	SetLogLevel(LevelOff)
+	serve()
+
+	if len(entries) != 0 {
+		t.Errorf("expected no entries at LevelOff got %d", len(entries))
+	}
+}
+
+func TestLoggerFormats(t *testing.T) {
+	e := &LogEntry{
+		Time:       time.Date(2017, 5, 29, 10, 0, 0, 0, time.UTC),
+		Level:      LevelWarn,
+		Handler:    "quake",
+		Method:     "GET",
+		Path:       "/quake",
+		Query:      "id=1",
+		Status:     http.StatusBadRequest,
+		Bytes:      12,
+		Duration:   1500 * time.Microsecond,
+		RemoteAddr: "192.0.2.1:1234",
+		RequestID:  "abc",
+		Msg:        `invalid "id"`,
+	}
+
+	var b bytes.Buffer
+
+	NewLogger(&b, LogLogfmt).Log(e)
+
+	l := `time=2017-05-29T10:00:00Z level=warn handler=quake method=GET path=/quake query="id=1" status=400 bytes=12 duration=1.5ms remote_addr=192.0.2.1:1234 request_id=abc msg="invalid \"id\""` + "\n"
+	if b.String() != l {
+		t.Errorf("expected\n%s got\n%s", l, b.String())
+	}
+
+	b.Reset()
+	NewLogger(&b, LogJSON).Log(e)
+
+	var j map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &j); err != nil {
+		t.Fatal(err)
+	}
+
+	if j["level"] != "warn" || j["status"] != 400.0 || j["duration_ms"] != 1.5 || j["msg"] != `invalid "id"` || j["request_id"] != "abc" {
+		t.Errorf("unexpected JSON %s", b.String())
+	}
+
+	if _, ok := j["encoding"]; ok {
+		t.Error("expected empty encoding to be omitted")
+	}
+
+	b.Reset()
+	NewLogger(&b, LogText).Log(e)
+
+	l = `2017-05-29T10:00:00Z warn: 400 GET /quake?id=1 1.5ms 12 bytes from 192.0.2.1:1234 request abc msg: invalid "id"` + "\n"
+	if b.String() != l {
+		t.Errorf("expected\n%s got\n%s", l, b.String())
+	}
+}
+
+// TestLogInjection checks a client can't start a new text log line with an encoded newline.
+func TestLogInjection(t *testing.T) {
+	var b bytes.Buffer
+
+	SetLogger(NewLogger(&b, LogText))
+	defer SetLogger(nil)
+
+	h := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return BadRequest("invalid " + r.URL.Path)
+	})
+
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/quake%0Awarn:%20200%20GET%20/admin?q=%0D%0A", nil))
+
+	if strings.Count(b.String(), "\n") != 1 {
+		t.Fatalf("expected a single log line got:\n%s", b.String())
+	}
+
+	if !strings.Contains(b.String(), `GET /quake\nwarn: 200 GET /admin?q=%0D%0A `) || !strings.Contains(b.String(), `msg: invalid /quake\nwarn: 200 GET /admin`+"\n") {
+		t.Errorf("expected escaped path and msg got:\n%s", b.String())
+	}
+}
@@ -0,0 +1,284 @@
+package weft
+
+import (
+	"bufio"
+	"github.com/GeoNet/mtr/mtrapp"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+/*
+Metrics records the requests served by handlers made with MakeHandlerPage,
+MakeHandlerAPI and MakeSimpleHandler.  Implementations must be safe for concurrent use.
+
+MtrMetrics is used by default.  See SetMetrics.
+*/
+type Metrics interface {
+	// Start is called when handler starts serving a request with method.
+	Start(handler, method string) MetricsTimer
+}
+
+/*
+MetricsTimer records a single request.
+
+Stop is called when the RequestHandler returns, before the response is written to the client.
+The time until Stop is the request latency.  Stop is not called for MakeSimpleHandler as the
+handler writes to the client itself.
+
+Done is called once with the status code after the response has been written.  This is the
+status code written to the client e.g., http.StatusNotModified, which may not be the Result.Code.
+*/
+type MetricsTimer interface {
+	Stop()
+	Done(code int)
+}
+
+var metrics = struct {
+	sync.RWMutex
+	m Metrics
+}{
+	m: MtrMetrics{},
+}
+
+// SetMetrics sets the Metrics for all handlers.  A nil m restores MtrMetrics.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = MtrMetrics{}
+	}
+
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	metrics.m = m
+}
+
+/*
+LoadMetrics is implemented by Metrics that record load shedding by handlers made
+WithConcurrencyLimit.
+
+Queue is called with the number of requests waiting for the handler when it changes.
+Rejected is called when a request is served http.StatusServiceUnavailable because the
+queue is full or the wait is too long.
+*/
+type LoadMetrics interface {
+	Queue(handler string, depth int)
+	Rejected(handler string)
+}
+
+// loadMetrics returns the current Metrics as LoadMetrics or nil if it isn't.
+func loadMetrics() LoadMetrics {
+	metrics.RLock()
+	defer metrics.RUnlock()
+
+	l, _ := metrics.m.(LoadMetrics)
+	return l
+}
+
+// startMetrics starts a MetricsTimer from the current Metrics.
+func startMetrics(handler, method string) MetricsTimer {
+	metrics.RLock()
+	defer metrics.RUnlock()
+
+	return metrics.m.Start(handler, method)
+}
+
+/*
+MtrMetrics sends metrics to MTR with github.com/GeoNet/mtr/mtrapp.  Requests
+are timed with the id handler.method and counted with Result.Count.
+
+mtrapp only has counters for some status codes so requests are also counted by
+handler with a timer for the status class and code written to the client e.g.,
+func1.GET.4xx and func1.GET.404.  A 303 is a successful POST followed by a GET
+redirect and is counted by Result.Count as a 200.
+
+MtrMetrics is LoadMetrics.  mtrapp has no gauges so requests that wait in the queue of a
+handler made WithConcurrencyLimit are counted with the timer handler.queue e.g., func1.queue
+and rejected requests with handler.rejected.
+
+mtrapp is configured from the MTR_* environment variables and drops metrics when they
+are not set.
+*/
+type MtrMetrics struct{}
+
+// mtrQueues is the last queue depth for each handler.
+var mtrQueues = struct {
+	sync.Mutex
+	depth map[string]int
+}{
+	depth: make(map[string]int),
+}
+
+func (MtrMetrics) Start(handler, method string) MetricsTimer {
+	return &mtrTimer{t: mtrapp.Start(), id: handler + "." + method}
+}
+
+type mtrTimer struct {
+	t       mtrapp.Timer
+	id      string
+	stopped bool
+}
+
+func (m *mtrTimer) Stop() {
+	m.t.Stop()
+	m.stopped = true
+}
+
+func (m *mtrTimer) Done(code int) {
+	if m.stopped {
+		m.t.Track(m.id)
+	}
+
+	for _, id := range mtrIDs(m.id, code) {
+		m.t.Track(id)
+	}
+
+	if code == http.StatusSeeOther {
+		code = http.StatusOK
+	}
+
+	res := Result{Code: code}
+	res.Count()
+}
+
+func (MtrMetrics) Queue(handler string, depth int) {
+	for i := mtrQueued(handler, depth); i > 0; i-- {
+		t := mtrapp.Start()
+		t.Track(handler + ".queue")
+	}
+}
+
+func (MtrMetrics) Rejected(handler string) {
+	t := mtrapp.Start()
+	t.Track(handler + ".rejected")
+}
+
+// mtrQueued records depth for handler and returns the number of requests added to the queue since the last depth.
+func mtrQueued(handler string, depth int) int {
+	mtrQueues.Lock()
+	defer mtrQueues.Unlock()
+
+	n := depth - mtrQueues.depth[handler]
+	mtrQueues.depth[handler] = depth
+
+	return n
+}
+
+// mtrIDs returns the timer ids for counting a request for id by status class and code.
+func mtrIDs(id string, code int) []string {
+	return []string{id + "." + statusClass(code), id + "." + strconv.Itoa(code)}
+}
+
+// MultiMetrics returns Metrics that records requests with all of m.
+func MultiMetrics(m ...Metrics) Metrics {
+	return multiMetrics(m)
+}
+
+type multiMetrics []Metrics
+
+func (m multiMetrics) Start(handler, method string) MetricsTimer {
+	t := make(multiTimer, len(m))
+	for i := range m {
+		t[i] = m[i].Start(handler, method)
+	}
+	return t
+}
+
+func (m multiMetrics) Queue(handler string, depth int) {
+	for _, v := range m {
+		if l, ok := v.(LoadMetrics); ok {
+			l.Queue(handler, depth)
+		}
+	}
+}
+
+func (m multiMetrics) Rejected(handler string) {
+	for _, v := range m {
+		if l, ok := v.(LoadMetrics); ok {
+			l.Rejected(handler)
+		}
+	}
+}
+
+type multiTimer []MetricsTimer
+
+func (t multiTimer) Stop() {
+	for _, v := range t {
+		v.Stop()
+	}
+}
+
+func (t multiTimer) Done(code int) {
+	for _, v := range t {
+		v.Done(code)
+	}
+}
+
+// statusClass returns the class for code e.g., "2xx".
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "unknown"
+	}
+
+	return string(rune('0'+code/100)) + "xx"
+}
+
+// statusWriter records the status code written to the client so that
+// 304, 206 etc are counted as written instead of the Result.Code.
+// The number of bytes written is recorded for the access log.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+	n    int64 // body bytes written.
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	// ignore informational responses e.g., 103 Early Hints.
+	if w.code == 0 && code >= 200 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	if w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Unwrap is used by http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// status returns the code written to the client or code if nothing has been written.
+func (w *statusWriter) status(code int) int {
+	if w.code == 0 {
+		return code
+	}
+	return w.code
+}
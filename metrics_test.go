@@ -0,0 +1,199 @@
+package weft
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	p := NewPrometheusMetrics(0.5, 0.1)
+	SetMetrics(p)
+	defer SetMetrics(nil)
+
+	ok := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString("ok")
+		return &StatusOK
+	})
+
+	notFound := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return &NotFound
+	})
+
+	simple := MakeSimpleHandler(func(r *http.Request, w http.ResponseWriter) *Result {
+		return BadRequest("simple")
+	})
+
+	for _, f := range []http.HandlerFunc{ok, ok, notFound, simple} {
+		f(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	}
+
+	// non-standard methods share one label value.
+	for _, m := range []string{"BOGUS", "get", "X-1"} {
+		ok(httptest.NewRecorder(), httptest.NewRequest(m, "/test", nil))
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", w.Code)
+	}
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected Content-Type %s", w.Header().Get("Content-Type"))
+	}
+
+	body := w.Body.String()
+
+	for _, l := range []string{
+		`weft_requests_total{handler="func1",method="GET",code="200",class="2xx"} 2`,
+		`weft_requests_total{handler="func2",method="GET",code="404",class="4xx"} 1`,
+		`weft_requests_total{handler="func3",method="GET",code="400",class="4xx"} 1`,
+		`weft_request_duration_seconds_bucket{handler="func1",method="GET",le="0.1"} 2`,
+		`weft_request_duration_seconds_bucket{handler="func1",method="GET",le="0.5"} 2`,
+		`weft_request_duration_seconds_bucket{handler="func1",method="GET",le="+Inf"} 2`,
+		`weft_request_duration_seconds_count{handler="func1",method="GET"} 2`,
+		`weft_requests_total{handler="func1",method="other",code="200",class="2xx"} 3`,
+	} {
+		if !strings.Contains(body, l+"\n") {
+			t.Errorf("expected line %s in:\n%s", l, body)
+		}
+	}
+
+	if strings.Contains(body, "BOGUS") {
+		t.Errorf("non-standard method should be labelled other:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "/metrics", nil))
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 got %d", w.Code)
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	if q := quoteLabel("a\"b\\c\nd"); q != `"a\"b\\c\nd"` {
+		t.Errorf("unexpected quoted label %s", q)
+	}
+}
+
+// countMetrics counts requests by handler and status code.
+type countMetrics struct {
+	sync.Mutex
+	n map[string]int
+}
+
+func (c *countMetrics) Start(handler, method string) MetricsTimer {
+	return countTimer{c: c, handler: handler}
+}
+
+type countTimer struct {
+	c       *countMetrics
+	handler string
+}
+
+func (t countTimer) Stop() {}
+
+func (t countTimer) Done(code int) {
+	t.c.Lock()
+	defer t.c.Unlock()
+
+	t.c.n[t.handler+"."+strconv.Itoa(code)]++
+}
+
+func TestMetricsStatusWritten(t *testing.T) {
+	c := &countMetrics{n: make(map[string]int)}
+	p := NewPrometheusMetrics()
+	SetMetrics(MultiMetrics(c, p))
+	defer SetMetrics(nil)
+
+	data := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString("0123456789")
+		return &StatusOK
+	})
+
+	post := MakeHandlerPage(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return SeeOther("/done")
+	})
+
+	w := httptest.NewRecorder()
+	data(w, httptest.NewRequest("GET", "/data", nil))
+
+	etag := w.Header().Get("ETag")
+
+	r := httptest.NewRequest("GET", "/data", nil)
+	r.Header.Set("If-None-Match", etag)
+	data(httptest.NewRecorder(), r)
+
+	r = httptest.NewRequest("GET", "/data", nil)
+	r.Header.Set("Range", "bytes=0-4")
+	data(httptest.NewRecorder(), r)
+
+	post(httptest.NewRecorder(), httptest.NewRequest("POST", "/post", nil))
+
+	for k, v := range map[string]int{
+		"func1.200": 1,
+		"func1.304": 1,
+		"func1.206": 1,
+		"func2.303": 1,
+	} {
+		if c.n[k] != v {
+			t.Errorf("expected %d for %s got %d", v, k, c.n[k])
+		}
+	}
+
+	var b bytes.Buffer
+	p.write(&b)
+
+	if !strings.Contains(b.String(), `weft_requests_total{handler="func1",method="GET",code="304",class="3xx"} 1`) {
+		t.Errorf("expected 304 in Prometheus metrics:\n%s", b.String())
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for code, class := range map[int]string{200: "2xx", 304: "3xx", 429: "4xx", 503: "5xx", 0: "unknown", 600: "unknown"} {
+		if c := statusClass(code); c != class {
+			t.Errorf("expected %s for %d got %s", class, code, c)
+		}
+	}
+}
+
+func TestMtrQueued(t *testing.T) {
+	var _ LoadMetrics = MtrMetrics{}
+
+	for i, v := range []struct{ depth, queued int }{{1, 1}, {2, 1}, {1, -1}, {0, -1}, {3, 3}} {
+		if n := mtrQueued("TestMtrQueued", v.depth); n != v.queued {
+			t.Errorf("%d expected %d queued got %d", i, v.queued, n)
+		}
+	}
+
+	// exercise the mtr path.
+	MtrMetrics{}.Queue("TestMtrQueued", 4)
+	MtrMetrics{}.Rejected("TestMtrQueued")
+}
+
+func TestMtrIDs(t *testing.T) {
+	ids := mtrIDs("func1.GET", http.StatusNotFound)
+
+	if len(ids) != 2 || ids[0] != "func1.GET.4xx" || ids[1] != "func1.GET.404" {
+		t.Errorf("unexpected mtr ids %v", ids)
+	}
+
+	// exercise the mtr path for a code mtrapp has no counter for.
+	h := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return &Result{Code: http.StatusSeeOther, Redirect: "/ok"}
+	})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("POST", "/test", nil))
+
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("expected 303 got %d", w.Code)
+	}
+}
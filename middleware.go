@@ -0,0 +1,103 @@
+package weft
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+/*
+Middleware wraps a RequestHandler with behaviour that is common to many handlers
+e.g., authentication or rate limiting.  A Middleware can return a Result without calling
+the next RequestHandler or inspect and change the Result from it on the way out e.g.,
+
+	func logErrors(next weft.RequestHandler) weft.RequestHandler {
+		return func(r *http.Request, h http.Header, b *bytes.Buffer) *weft.Result {
+			res := next(r, h, b)
+			if !res.Ok {
+				log.Print(res.Msg)
+			}
+			return res
+		}
+	}
+*/
+type Middleware func(RequestHandler) RequestHandler
+
+/*
+Chain returns f wrapped with m.  The first Middleware in m is the outermost; it is
+called first and sees the Result from f last.
+*/
+func Chain(f RequestHandler, m ...Middleware) RequestHandler {
+	for i := len(m) - 1; i >= 0; i-- {
+		f = m[i](f)
+	}
+
+	return f
+}
+
+/*
+WithMiddleware wraps the RequestHandler for a handler with m.  See Chain for the order
+Middleware are called in.  Using WithMiddleware more than once appends to the chain.
+Middleware are inside any timeout and panic recovery for the handler.  The handler
+name for metrics is the name of the RequestHandler, not the Middleware.
+*/
+func WithMiddleware(m ...Middleware) Option {
+	return func(h *handler) {
+		h.middleware = append(h.middleware, m...)
+	}
+}
+
+// Methods returns a Middleware that serves MethodNotAllowed with an Allow header
+// for requests with a method that is not in methods.
+func Methods(methods ...string) Middleware {
+	allow := strings.Join(methods, ", ")
+
+	return func(next RequestHandler) RequestHandler {
+		return func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+			for _, m := range methods {
+				if r.Method == m {
+					return next(r, h, b)
+				}
+			}
+
+			h.Set("Allow", allow)
+			return &MethodNotAllowed
+		}
+	}
+}
+
+// QueryParams returns a Middleware that checks the query parameters for requests with CheckQuery.
+func QueryParams(required, optional []string) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+			if res := CheckQuery(r, required, optional); !res.Ok {
+				return res
+			}
+
+			return next(r, h, b)
+		}
+	}
+}
+
+// SetHeader returns a Middleware that sets the response header key to value
+// e.g., SetHeader("Access-Control-Allow-Origin", "*").
+func SetHeader(key, value string) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+			h.Set(key, value)
+			return next(r, h, b)
+		}
+	}
+}
+
+// OnResult returns a Middleware that calls f with the request and the Result from the
+// next RequestHandler e.g., for auditing requests that change data.
+func OnResult(f func(r *http.Request, res *Result)) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+			res := next(r, h, b)
+			f(r, res)
+			return res
+		}
+	}
+}
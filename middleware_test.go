@@ -0,0 +1,95 @@
+package weft
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+
+	m := func(id string) Middleware {
+		return func(next RequestHandler) RequestHandler {
+			return func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+				order = append(order, id+" in")
+				res := next(r, h, b)
+				order = append(order, id+" out")
+				return res
+			}
+		}
+	}
+
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		order = append(order, "f")
+		return &StatusOK
+	}
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	MakeHandlerAPI(f, WithMiddleware(m("a"), m("b")), WithMiddleware(m("c"))).ServeHTTP(httptest.NewRecorder(), r)
+
+	if strings.Join(order, ",") != "a in,b in,c in,f,c out,b out,a out" {
+		t.Errorf("middleware called in wrong order: %s", strings.Join(order, ","))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var results []int
+
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString("ok")
+		return &StatusOK
+	}
+
+	fm := MakeHandlerAPI(f, WithMiddleware(
+		OnResult(func(r *http.Request, res *Result) {
+			results = append(results, res.Code)
+		}),
+		SetHeader("Access-Control-Allow-Origin", "*"),
+		Methods("GET", "HEAD"),
+		QueryParams(nil, []string{"optional"}),
+	))
+
+	in := []struct {
+		method, url string
+		code        int
+		allow       string
+	}{
+		{method: "GET", url: "http://test.com", code: http.StatusOK},
+		{method: "GET", url: "http://test.com?optional=1", code: http.StatusOK},
+		{method: "GET", url: "http://test.com?extra=1", code: http.StatusBadRequest},
+		{method: "PUT", url: "http://test.com", code: http.StatusMethodNotAllowed, allow: "GET, HEAD"},
+	}
+
+	for i, v := range in {
+		r, err := http.NewRequest(v.method, v.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != v.code {
+			t.Errorf("%d expected status %d got %d", i, v.code, w.Code)
+		}
+
+		if w.Header().Get("Allow") != v.allow {
+			t.Errorf("%d expected Allow %s got %s", i, v.allow, w.Header().Get("Allow"))
+		}
+
+		if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("%d expected Access-Control-Allow-Origin header", i)
+		}
+
+		if results[i] != v.code {
+			t.Errorf("%d OnResult expected %d got %d", i, v.code, results[i])
+		}
+	}
+}
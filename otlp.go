@@ -0,0 +1,185 @@
+package weft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+/*
+OTLPExporter is a SpanExporter that writes spans in the OpenTelemetry protocol (OTLP) JSON
+encoding.  Each span is written to w as an ExportTraceServiceRequest on its own line.
+This is the format read by the OpenTelemetry Collector otlpjsonfile receiver and accepted
+by OTLP/HTTP endpoints (/v1/traces) with Content-Type application/json e.g.,
+
+	f, err := os.OpenFile("spans.json", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	...
+	weft.SetSpanExporter(weft.NewOTLPExporter(f, "fdsn-ws"))
+
+Trace and span IDs are kept so spans join traces from other services.
+*/
+type OTLPExporter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	service string
+}
+
+// NewOTLPExporter returns an OTLPExporter that writes to w.  service is the
+// service.name resource attribute for the spans.
+func NewOTLPExporter(w io.Writer, service string) *OTLPExporter {
+	return &OTLPExporter{w: w, service: service}
+}
+
+// OTLP span kinds and status codes.
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpStatusError  = 2
+)
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is an OTLP AnyValue.  int64 values are strings in the JSON encoding.
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func (o *OTLPExporter) Export(s *Span) {
+	sp := otlpSpan{
+		TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+		Name:              s.Name,
+		Kind:              otlpKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+	}
+
+	if s.Parent.IsValid() {
+		sp.ParentSpanID = hex.EncodeToString(s.Parent.SpanID[:])
+	}
+
+	if s.server {
+		sp.Kind = otlpKindServer
+	}
+
+	keys := make([]string, 0, len(s.Attributes))
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		sp.Attributes = append(sp.Attributes, otlpKeyValue{Key: k, Value: otlpAttribute(s.Attributes[k])})
+	}
+
+	if s.Error {
+		m, _ := s.Attributes["error.message"].(string)
+		sp.Status = &otlpStatus{Code: otlpStatusError, Message: m}
+	}
+
+	req := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpAttribute(o.service)}}},
+			ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/GeoNet/weft"}, Spans: []otlpSpan{sp}}},
+		}},
+	}
+
+	var b bytes.Buffer
+
+	// can't fail, otlpAttribute only returns values that encode.
+	json.NewEncoder(&b).Encode(req)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.w.Write(b.Bytes())
+}
+
+// otlpAttribute returns v as an OTLP value.  Types with no OTLP value are formatted as strings.
+func otlpAttribute(v interface{}) otlpValue {
+	var i int64
+
+	switch x := v.(type) {
+	case string:
+		return otlpValue{StringValue: &x}
+	case bool:
+		return otlpValue{BoolValue: &x}
+	case float64:
+		return otlpDouble(x)
+	case float32:
+		return otlpDouble(float64(x))
+	case int:
+		i = int64(x)
+	case int64:
+		i = x
+	case int32:
+		i = int64(x)
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+
+	s := strconv.FormatInt(i, 10)
+	return otlpValue{IntValue: &s}
+}
+
+// otlpDouble returns f as an OTLP value.  NaN and Inf can't be encoded as JSON numbers.
+func otlpDouble(f float64) otlpValue {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		return otlpValue{StringValue: &s}
+	}
+
+	return otlpValue{DoubleValue: &f}
+}
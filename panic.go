@@ -0,0 +1,75 @@
+package weft
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+)
+
+// panicHandler is called when a handler panics.
+var panicHandler struct {
+	sync.RWMutex
+	f func(r *http.Request, v interface{}, stack []byte)
+}
+
+/*
+SetPanicHandler sets f to be called when a handler made with MakeHandlerPage, MakeHandlerAPI
+or MakeSimpleHandler panics.  f is called with the request, the value passed to panic and the
+stack trace e.g., to forward the panic to incident tooling.  f should not block for long.
+
+Panics are always logged with their stack trace and served as http.StatusInternalServerError.
+*/
+func SetPanicHandler(f func(r *http.Request, v interface{}, stack []byte)) {
+	panicHandler.Lock()
+	defer panicHandler.Unlock()
+
+	panicHandler.f = f
+}
+
+// safe executes h.f recovering from any panic.
+func (h *handler) safe(r *http.Request, header http.Header, b *bytes.Buffer) (res *Result) {
+	// headers set before f e.g., X-Request-ID are kept if f panics.
+	before := header.Clone()
+
+	defer func() {
+		if v := recover(); v != nil {
+			// discard anything f had started on the response.
+			for k := range header {
+				delete(header, k)
+			}
+			for k, v := range before {
+				header[k] = v
+			}
+			b.Reset()
+
+			res = recovered(r, v)
+		}
+	}()
+
+	return h.f(r, header, b)
+}
+
+// recovered logs the panic v while serving r and returns the Result for it.
+// http.ErrAbortHandler is panicked again to abort the response as net/http expects.
+func recovered(r *http.Request, v interface{}) *Result {
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+
+	stack := debug.Stack()
+
+	log.Printf("panic: serving %s request %s: %v\n%s", r.RequestURI, RequestID(r), v, stack)
+
+	panicHandler.RLock()
+	f := panicHandler.f
+	panicHandler.RUnlock()
+
+	if f != nil {
+		f(r, v, stack)
+	}
+
+	return &Result{Ok: false, Code: http.StatusInternalServerError, Msg: fmt.Sprintf("panic: %v", v)}
+}
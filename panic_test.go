@@ -0,0 +1,93 @@
+package weft
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	var panics []interface{}
+
+	SetPanicHandler(func(r *http.Request, v interface{}, stack []byte) {
+		if len(stack) == 0 {
+			t.Error("expected a stack trace")
+		}
+		panics = append(panics, v)
+	})
+	defer SetPanicHandler(nil)
+
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		h.Set("Content-Type", "application/json")
+		b.WriteString("{")
+		panic("boom")
+	}
+
+	fc := func(ctx context.Context, r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		panic("boom")
+	}
+
+	fs := func(r *http.Request, w http.ResponseWriter) *Result {
+		panic("boom")
+	}
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	MakeHandlerPage(f).ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusInternalServerError, "max-age=10", "", err500)
+
+	// headers set by weft before f are kept.
+	if w.Header().Get("X-Request-ID") == "" {
+		t.Error("expected X-Request-ID on the recovered response")
+	}
+
+	w = httptest.NewRecorder()
+	MakeHandlerAPI(f).ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusInternalServerError, "max-age=10", "", "panic: boom")
+
+	if w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("expected text/plain; charset=utf-8 got %s", w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	MakeHandlerAPIContext(fc, WithTimeout(time.Second)).ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusInternalServerError, "max-age=10", "", "panic: boom")
+
+	w = httptest.NewRecorder()
+	MakeSimpleHandler(fs).ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusInternalServerError, "max-age=10", "", "panic: boom")
+
+	if len(panics) != 4 {
+		t.Errorf("expected 4 panics got %d", len(panics))
+	}
+}
+
+func TestRecoverPanicAbort(t *testing.T) {
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		panic(http.ErrAbortHandler)
+	}
+
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h := range []http.HandlerFunc{MakeHandlerAPI(f), MakeHandlerAPI(f, WithTimeout(time.Second))} {
+		func() {
+			defer func() {
+				if v := recover(); v != http.ErrAbortHandler {
+					t.Errorf("expected http.ErrAbortHandler got %v", v)
+				}
+			}()
+
+			h.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+	}
+}
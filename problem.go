@@ -0,0 +1,82 @@
+package weft
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ErrorFormat is the format MakeHandlerAPI uses to write the body for Results
+// where Code is not http.StatusOK.
+type ErrorFormat int
+
+const (
+	// ErrorText writes Result.Msg as text/plain.  This is the default.
+	ErrorText ErrorFormat = iota
+	// ErrorProblemJSON writes RFC 7807 application/problem+json when the request
+	// Accept header accepts application/problem+json or application/json.
+	// Other requests get ErrorText.
+	ErrorProblemJSON
+)
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+	// RequestID is an extension member for the request ID.  See WithRequestIDInErrors.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// WithErrorFormat sets the format for error bodies written by MakeHandlerAPI.
+func WithErrorFormat(f ErrorFormat) Option {
+	return func(h *handler) {
+		h.errorFormat = f
+	}
+}
+
+// acceptsProblem returns true if the client accepts a problem+json response.
+func acceptsProblem(r *http.Request) bool {
+	a := r.Header.Get("Accept")
+	if a == "" {
+		return false
+	}
+
+	q := parseQValues(a)
+
+	return q["application/problem+json"] > 0 || q["application/json"] > 0
+}
+
+// writeProblem writes res to b as problem+json for the request r.
+// The request ID is included if requestID is true.
+func writeProblem(b *bytes.Buffer, r *http.Request, res *Result, requestID bool) {
+	p := Problem{
+		Type:     res.Type,
+		Title:    http.StatusText(res.Code),
+		Status:   res.Code,
+		Detail:   res.Msg,
+		Instance: r.URL.RequestURI(),
+		Details:  res.Details,
+	}
+
+	if requestID {
+		p.RequestID = RequestID(r)
+	}
+
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+
+	if err := json.NewEncoder(b).Encode(p); err != nil {
+		log.Printf("WARN: weft - error encoding problem+json: %s", err.Error())
+
+		// try again without the Details from the Result.
+		b.Reset()
+		p.Details = nil
+		json.NewEncoder(b).Encode(p)
+	}
+}
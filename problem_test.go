@@ -0,0 +1,88 @@
+package weft
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProblemJSON(t *testing.T) {
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return &Result{
+			Code:    http.StatusBadRequest,
+			Msg:     "invalid time",
+			Type:    "invalid-time",
+			Details: map[string]string{"time": "not RFC3339"},
+		}
+	}
+
+	fm := MakeHandlerAPI(f, WithErrorFormat(ErrorProblemJSON))
+
+	r, err := http.NewRequest("GET", "http://test.com/quake?time=bad", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// text/plain by default
+	w := httptest.NewRecorder()
+	fm.ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusBadRequest, "max-age=86400", "", "invalid time")
+
+	if w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("expected text/plain; charset=utf-8 got %s", w.Header().Get("Content-Type"))
+	}
+
+	for _, a := range []string{"application/problem+json", "text/html;q=0.9, application/json;q=0.5"} {
+		r.Header.Set("Accept", a)
+		w = httptest.NewRecorder()
+		fm.ServeHTTP(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s expected status 400 got %d", a, w.Code)
+		}
+
+		if w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s expected application/problem+json got %s", a, w.Header().Get("Content-Type"))
+		}
+
+		var p struct {
+			Problem
+			Details map[string]string `json:"details"`
+		}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Type != "invalid-time" || p.Title != "Bad Request" || p.Status != http.StatusBadRequest ||
+			p.Detail != "invalid time" || p.Instance != "/quake?time=bad" || p.Details["time"] != "not RFC3339" {
+			t.Errorf("%s got wrong problem %+v", a, p)
+		}
+	}
+
+	// the default format is text/plain regardless of Accept.
+	w = httptest.NewRecorder()
+	MakeHandlerAPI(f).ServeHTTP(w, r)
+	checkResponse(t, w, http.StatusBadRequest, "max-age=86400", "", "invalid time")
+}
+
+func TestProblemJSONDefaults(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://test.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	writeProblem(&b, r, &Result{Code: http.StatusNotFound, Details: func() {}}, false)
+
+	var p Problem
+	if err := json.Unmarshal(b.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Type != "about:blank" || p.Title != "Not Found" || p.Details != nil {
+		t.Errorf("got wrong problem %+v", p)
+	}
+}
@@ -0,0 +1,264 @@
+package weft
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the latency histogram upper bounds in seconds used by NewPrometheusMetrics.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+/*
+PrometheusMetrics is Metrics in memory that are served in the Prometheus text exposition
+format by ServeHTTP e.g.,
+
+	p := weft.NewPrometheusMetrics()
+	weft.SetMetrics(p)
+	mux.Handle("/metrics", p)
+
+The metrics are:
+
+	weft_requests_total{handler, method, code, class} - counter of requests by the status code
+	  written to the client and its class e.g., code="304",class="3xx".
+	weft_request_duration_seconds{handler, method} - histogram of request latency.
+	weft_queue_depth{handler} - gauge of requests waiting, see WithConcurrencyLimit.
+	weft_rejected_total{handler} - counter of requests rejected by WithConcurrencyLimit.
+
+Methods other than the standard HTTP methods are labelled method="other" so clients
+can't create an unbounded number of series.
+*/
+type PrometheusMetrics struct {
+	buckets  []float64
+	mu       sync.Mutex
+	requests map[promRequest]uint64
+	latency  map[promHandler]*histogram
+	queue    map[string]int
+	rejected map[string]uint64
+}
+
+type promHandler struct {
+	handler, method string
+}
+
+type promRequest struct {
+	promHandler
+	code int
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative.
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheusMetrics returns PrometheusMetrics with the latency histogram buckets (upper bounds
+// in seconds).  DefaultBuckets are used if none are given.
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+
+	return &PrometheusMetrics{
+		buckets:  b,
+		requests: make(map[promRequest]uint64),
+		latency:  make(map[promHandler]*histogram),
+		queue:    make(map[string]int),
+		rejected: make(map[string]uint64),
+	}
+}
+
+func (p *PrometheusMetrics) Start(handler, method string) MetricsTimer {
+	return &promTimer{p: p, h: promHandler{handler: handler, method: methodLabel(method)}, start: time.Now()}
+}
+
+// methodLabel returns method for the standard HTTP methods and "other" for anything else.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+
+	return "other"
+}
+
+type promTimer struct {
+	p     *PrometheusMetrics
+	h     promHandler
+	start time.Time
+	taken time.Duration
+}
+
+func (t *promTimer) Stop() {
+	t.taken = time.Since(t.start)
+}
+
+func (t *promTimer) Done(code int) {
+	if t.taken == 0 {
+		t.Stop()
+	}
+
+	t.p.observe(promRequest{promHandler: t.h, code: code}, t.taken)
+}
+
+func (p *PrometheusMetrics) observe(k promRequest, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests[k]++
+
+	h, ok := p.latency[k.promHandler]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		p.latency[k.promHandler] = h
+	}
+
+	s := d.Seconds()
+
+	h.count++
+	h.sum += s
+
+	for i, u := range p.buckets {
+		if s <= u {
+			h.counts[i]++
+			break
+		}
+	}
+}
+
+func (p *PrometheusMetrics) Queue(handler string, depth int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queue[handler] = depth
+}
+
+func (p *PrometheusMetrics) Rejected(handler string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rejected[handler]++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		Write(w, r, &MethodNotAllowed)
+		return
+	}
+
+	var b bytes.Buffer
+	p.write(&b)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	b.WriteTo(w)
+}
+
+// write writes the metrics to b in the Prometheus text exposition format.
+func (p *PrometheusMetrics) write(b *bytes.Buffer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	requests := make([]promRequest, 0, len(p.requests))
+	for k := range p.requests {
+		requests = append(requests, k)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].promHandler != requests[j].promHandler {
+			return requests[i].promHandler.less(requests[j].promHandler)
+		}
+		return requests[i].code < requests[j].code
+	})
+
+	b.WriteString("# HELP weft_requests_total Requests served by weft handlers.\n")
+	b.WriteString("# TYPE weft_requests_total counter\n")
+
+	for _, k := range requests {
+		fmt.Fprintf(b, "weft_requests_total{handler=%s,method=%s,code=\"%d\",class=\"%s\"} %d\n",
+			quoteLabel(k.handler), quoteLabel(k.method), k.code, statusClass(k.code), p.requests[k])
+	}
+
+	handlers := make([]promHandler, 0, len(p.latency))
+	for k := range p.latency {
+		handlers = append(handlers, k)
+	}
+
+	sort.Slice(handlers, func(i, j int) bool {
+		return handlers[i].less(handlers[j])
+	})
+
+	b.WriteString("# HELP weft_request_duration_seconds Latency of requests served by weft handlers.\n")
+	b.WriteString("# TYPE weft_request_duration_seconds histogram\n")
+
+	for _, k := range handlers {
+		h := p.latency[k]
+		labels := "handler=" + quoteLabel(k.handler) + ",method=" + quoteLabel(k.method)
+
+		var c uint64
+		for i, u := range p.buckets {
+			c += h.counts[i]
+			fmt.Fprintf(b, "weft_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(u, 'g', -1, 64), c)
+		}
+
+		fmt.Fprintf(b, "weft_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(b, "weft_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "weft_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	if len(p.queue) > 0 {
+		b.WriteString("# HELP weft_queue_depth Requests waiting for handlers with a concurrency limit.\n")
+		b.WriteString("# TYPE weft_queue_depth gauge\n")
+
+		keys := make([]string, 0, len(p.queue))
+		for k := range p.queue {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fmt.Fprintf(b, "weft_queue_depth{handler=%s} %d\n", quoteLabel(k), p.queue[k])
+		}
+	}
+
+	if len(p.rejected) > 0 {
+		b.WriteString("# HELP weft_rejected_total Requests rejected by handlers with a concurrency limit.\n")
+		b.WriteString("# TYPE weft_rejected_total counter\n")
+
+		keys := make([]string, 0, len(p.rejected))
+		for k := range p.rejected {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fmt.Fprintf(b, "weft_rejected_total{handler=%s} %d\n", quoteLabel(k), p.rejected[k])
+		}
+	}
+}
+
+func (k promHandler) less(o promHandler) bool {
+	if k.handler != o.handler {
+		return k.handler < o.handler
+	}
+	return k.method < o.method
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel returns s quoted and escaped as a Prometheus label value.
+func quoteLabel(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,124 @@
+package weft
+
+import (
+	"net/http"
+	"strings"
+)
+
+// surrogateKeyHeader is the response header surrogate keys are written to.
+var surrogateKeyHeader = struct {
+	name, sep string
+}{
+	name: "Surrogate-Key",
+	sep:  " ",
+}
+
+// Purger invalidates cached responses tagged with any of keys.
+// Handlers for PUT and DELETE requests can use a Purger to remove stale responses from caches.
+type Purger interface {
+	Purge(keys ...string) error
+}
+
+// PurgerFunc is an adapter to allow the use of ordinary functions as a Purger.
+type PurgerFunc func(keys ...string) error
+
+func (f PurgerFunc) Purge(keys ...string) error {
+	return f(keys...)
+}
+
+// multiPurger purges keys from all of its Purgers.
+type multiPurger []Purger
+
+// MultiPurger returns a Purger that purges keys from all of p e.g., a ResponseCache and a CDN.
+// Returns the first error.
+func MultiPurger(p ...Purger) Purger {
+	return multiPurger(p)
+}
+
+func (m multiPurger) Purge(keys ...string) error {
+	var err error
+
+	for _, p := range m {
+		if e := p.Purge(keys...); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+/*
+SetSurrogateKeyHeader sets the response header surrogate keys are written to and the separator
+between keys.  The default is Surrogate-Key with a space separator.  Other CDNs use
+different headers e.g., SetSurrogateKeyHeader("Cache-Tag", ",").
+
+SetSurrogateKeyHeader should be called before serving requests.
+*/
+func SetSurrogateKeyHeader(name, sep string) {
+	surrogateKeyHeader.name = http.CanonicalHeaderKey(name)
+	surrogateKeyHeader.sep = sep
+}
+
+/*
+AddSurrogateKeys tags the response with surrogate keys so that it can be purged from caches
+by key.  Call it from a RequestHandler with the header h e.g.,
+
+	weft.AddSurrogateKeys(h, "quake", "quake/"+publicID)
+
+The keys are written with the keys from the CachePolicy to the header set with SetSurrogateKeyHeader.
+*/
+func AddSurrogateKeys(h http.Header, keys ...string) {
+	for _, k := range keys {
+		h.Add("Surrogate-Key", k)
+	}
+}
+
+// writeSurrogateKeys merges the keys added to h with keys and writes them to the surrogate key header.
+func writeSurrogateKeys(h http.Header, keys []string) {
+	k := append(splitKeys(h["Surrogate-Key"]), keys...)
+
+	h.Del("Surrogate-Key")
+
+	if len(k) == 0 {
+		return
+	}
+
+	seen := make(map[string]bool, len(k))
+	var u []string
+
+	for _, v := range k {
+		if !seen[v] {
+			seen[v] = true
+			u = append(u, v)
+		}
+	}
+
+	h.Set(surrogateKeyHeader.name, strings.Join(u, surrogateKeyHeader.sep))
+}
+
+// splitKeys splits header values into keys.  Keys are separated with spaces or commas.
+func splitKeys(values []string) []string {
+	var k []string
+
+	for _, v := range values {
+		k = append(k, strings.FieldsFunc(v, func(r rune) bool {
+			return r == ' ' || r == ','
+		})...)
+	}
+
+	return k
+}
+
+// keySet is a set of surrogate keys.
+type keySet map[string]bool
+
+// hasAny returns true if any of keys are in s.
+func (s keySet) hasAny(keys []string) bool {
+	for _, k := range keys {
+		if s[k] {
+			return true
+		}
+	}
+
+	return false
+}
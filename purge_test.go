@@ -0,0 +1,85 @@
+package weft
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSurrogateKeys(t *testing.T) {
+	p := &CachePolicy{
+		Default: CacheRule{SurrogateMaxAge: 10, SurrogateKeys: []string{"all"}},
+	}
+
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		AddSurrogateKeys(h, "quake", "quake/2017p123456")
+		AddSurrogateKeys(h, "quake", "all")
+		b.WriteString(r.URL.Path)
+		return &StatusOK
+	}
+
+	r, err := http.NewRequest("GET", "http://test.com/quake", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	MakeHandlerAPI(f, WithCachePolicy(p)).ServeHTTP(w, r)
+
+	if w.Header().Get("Surrogate-Key") != "quake quake/2017p123456 all" {
+		t.Errorf("got wrong Surrogate-Key %s", w.Header().Get("Surrogate-Key"))
+	}
+
+	SetSurrogateKeyHeader("cache-tag", ",")
+	defer SetSurrogateKeyHeader("Surrogate-Key", " ")
+
+	w = httptest.NewRecorder()
+	MakeHandlerAPI(f).ServeHTTP(w, r)
+
+	if w.Header().Get("Cache-Tag") != "quake,quake/2017p123456,all" {
+		t.Errorf("got wrong Cache-Tag %s", w.Header().Get("Cache-Tag"))
+	}
+
+	if w.Header().Get("Surrogate-Key") != "" {
+		t.Errorf("expected no Surrogate-Key got %s", w.Header().Get("Surrogate-Key"))
+	}
+}
+
+func TestResponseCachePurge(t *testing.T) {
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		AddSurrogateKeys(h, r.URL.Path)
+		b.WriteString(r.URL.Path)
+		return &StatusOK
+	}
+
+	c := NewResponseCache(0, 0)
+	fm := MakeHandlerAPI(f, WithCache(c))
+
+	for _, u := range []string{"http://test.com/a", "http://test.com/b", "http://test.com/c"} {
+		r, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	var purged []string
+	fake := PurgerFunc(func(keys ...string) error {
+		purged = append(purged, keys...)
+		return errors.New("purge failed")
+	})
+
+	if err := MultiPurger(fake, c).Purge("/a", "/c"); err == nil {
+		t.Error("expected error from MultiPurger")
+	}
+
+	if c.Len() != 1 {
+		t.Errorf("expected 1 cache entry after purge got %d", c.Len())
+	}
+
+	if len(purged) != 2 {
+		t.Errorf("expected 2 purged keys got %d", len(purged))
+	}
+}
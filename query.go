@@ -0,0 +1,217 @@
+package weft
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+QueryParam describes a query parameter for ValidateQuery e.g.,
+
+	weft.QueryParam{Name: "resolution", Valid: weft.Enum("minute", "hour", "day")}
+*/
+type QueryParam struct {
+	Name     string
+	Required bool                     // the parameter must be present with a non zero value.
+	Repeated bool                     // the parameter may be given more than once.
+	Valid    func(value string) error // validates each value for the parameter.  nil for any value.
+}
+
+/*
+ValidateQuery inspects r and makes sure all required query parameters are present,
+that there are no query parameters other than those in params, and that all values are valid.
+Like CheckQuery cache busters are rejected.
+
+Returns a BadRequest listing every invalid parameter.  Result.Details is a map[string]string
+of parameter name to error for problem+json responses.
+*/
+func ValidateQuery(r *http.Request, params ...QueryParam) *Result {
+	if strings.Contains(r.URL.Path, ";") {
+		return BadRequest("cache buster")
+	}
+
+	v := r.URL.Query()
+	invalid := make(map[string]string)
+	var msg []string
+
+	for _, p := range params {
+		values, ok := v[p.Name]
+		delete(v, p.Name)
+
+		var err error
+
+		switch {
+		case !ok || (len(values) == 1 && values[0] == ""):
+			if p.Required {
+				err = errors.New("missing required query parameter")
+			}
+		case len(values) > 1 && !p.Repeated:
+			err = errors.New("must only be given once")
+		case p.Valid != nil:
+			for _, s := range values {
+				if err = p.Valid(s); err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			invalid[p.Name] = err.Error()
+			msg = append(msg, p.Name+": "+err.Error())
+		}
+	}
+
+	var extra []string
+	for k := range v {
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+
+	for _, k := range extra {
+		invalid[k] = "unexpected query parameter"
+		msg = append(msg, k+": unexpected query parameter")
+	}
+
+	if len(msg) == 0 {
+		return &StatusOK
+	}
+
+	res := BadRequest("invalid query parameters: " + strings.Join(msg, "; "))
+	res.Type = "invalid-query"
+	res.Details = invalid
+
+	return res
+}
+
+// Int returns a validator for base 10 integers.
+func Int() func(string) error {
+	return func(s string) error {
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		return nil
+	}
+}
+
+// IntRange returns a validator for base 10 integers between min and max inclusive.
+func IntRange(min, max int64) func(string) error {
+	return func(s string) error {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		if i < min || i > max {
+			return fmt.Errorf("%d is not between %d and %d", i, min, max)
+		}
+		return nil
+	}
+}
+
+// Float returns a validator for finite floating point numbers.
+func Float() func(string) error {
+	return func(s string) error {
+		if _, err := parseFinite(s); err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		return nil
+	}
+}
+
+// FloatRange returns a validator for floating point numbers between min and max inclusive.
+func FloatRange(min, max float64) func(string) error {
+	return func(s string) error {
+		f, err := parseFinite(s)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		if f < min || f > max {
+			return fmt.Errorf("%g is not between %g and %g", f, min, max)
+		}
+		return nil
+	}
+}
+
+// parseFinite parses s as a float64 rejecting NaN and Inf, which pass all range checks.
+func parseFinite(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New("not a finite number")
+	}
+	return f, nil
+}
+
+// Time returns a validator for RFC3339 times e.g., 2017-05-29T10:00:00Z.
+func Time() func(string) error {
+	return func(s string) error {
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Errorf("invalid RFC3339 time %q", s)
+		}
+		return nil
+	}
+}
+
+// Enum returns a validator that accepts only values.
+func Enum(values ...string) func(string) error {
+	return func(s string) error {
+		for _, v := range values {
+			if s == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", s, strings.Join(values, ", "))
+	}
+}
+
+// Match returns a validator for values that match re.  Anchor re to match the whole value.
+func Match(re *regexp.Regexp) func(string) error {
+	return func(s string) error {
+		if !re.MatchString(s) {
+			return fmt.Errorf("%q does not match %s", s, re.String())
+		}
+		return nil
+	}
+}
+
+/*
+BoundingBox returns a validator for a bounding box given as minLon,minLat,maxLon,maxLat
+e.g., 165,-48,179,-34.  Longitudes must be between -180 and 360 (to allow boxes that cross
+the antimeridian) and latitudes between -90 and 90.  The min values must be less than the max values.
+*/
+func BoundingBox() func(string) error {
+	return func(s string) error {
+		p := strings.Split(s, ",")
+		if len(p) != 4 {
+			return fmt.Errorf("invalid bounding box %q expected minLon,minLat,maxLon,maxLat", s)
+		}
+
+		var f [4]float64
+
+		for i := range p {
+			var err error
+			if f[i], err = parseFinite(strings.TrimSpace(p[i])); err != nil {
+				return fmt.Errorf("invalid bounding box %q expected minLon,minLat,maxLon,maxLat", s)
+			}
+		}
+
+		switch {
+		case f[0] < -180 || f[0] > 360 || f[2] < -180 || f[2] > 360:
+			return fmt.Errorf("invalid bounding box %q longitude out of range", s)
+		case f[1] < -90 || f[1] > 90 || f[3] < -90 || f[3] > 90:
+			return fmt.Errorf("invalid bounding box %q latitude out of range", s)
+		case f[0] >= f[2] || f[1] >= f[3]:
+			return fmt.Errorf("invalid bounding box %q min must be less than max", s)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,78 @@
+package weft
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestValidateQuery(t *testing.T) {
+	params := []QueryParam{
+		{Name: "time", Required: true, Valid: Time()},
+		{Name: "resolution", Valid: Enum("minute", "hour")},
+		{Name: "limit", Valid: IntRange(1, 100)},
+		{Name: "id", Repeated: true, Valid: Int()},
+		{Name: "bbox", Valid: BoundingBox()},
+		{Name: "depth", Valid: FloatRange(0, 800)},
+		{Name: "code", Valid: Match(regexp.MustCompile(`^[A-Z]{4}$`))},
+		{Name: "mag", Valid: Float()},
+	}
+
+	in := []struct {
+		query   string
+		invalid []string
+	}{
+		{query: "time=2017-05-29T10:00:00Z"},
+		{query: "time=2017-05-29T10:00:00Z&resolution=hour&limit=100&id=1&id=2&bbox=165,-48,179,-34&depth=5.5&code=WEL1", invalid: []string{"code"}},
+		{query: "time=2017-05-29T10:00:00Z&resolution=hour&limit=100&id=1&id=2&bbox=165,-48,179,-34&depth=5.5&code=WELL"},
+		{query: "", invalid: []string{"time"}},
+		{query: "time=yesterday&resolution=week&limit=0&id=x&bbox=1,2,3&depth=-1", invalid: []string{"time", "resolution", "limit", "id", "bbox", "depth"}},
+		{query: "time=2017-05-29T10:00:00Z&time=2017-05-29T10:00:00Z", invalid: []string{"time"}},
+		{query: "time=2017-05-29T10:00:00Z&bbox=179,-48,165,-34&cache=busta", invalid: []string{"bbox", "cache"}},
+		{query: "time=2017-05-29T10:00:00Z&bbox=NaN,-48,179,-34&depth=NaN&mag=NaN", invalid: []string{"bbox", "depth", "mag"}},
+		{query: "time=2017-05-29T10:00:00Z&bbox=165,-Inf,179,%2BInf&depth=Inf&mag=-Inf", invalid: []string{"bbox", "depth", "mag"}},
+		{query: "time=2017-05-29T10:00:00Z&mag=-1.5"},
+	}
+
+	for i, v := range in {
+		r, err := http.NewRequest("GET", "http://test.com/path?"+v.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := ValidateQuery(r, params...)
+
+		if len(v.invalid) == 0 {
+			if !res.Ok {
+				t.Errorf("%d expected ok got %s", i, res.Msg)
+			}
+			continue
+		}
+
+		if res.Ok || res.Code != http.StatusBadRequest {
+			t.Errorf("%d expected bad request", i)
+			continue
+		}
+
+		d := res.Details.(map[string]string)
+
+		if len(d) != len(v.invalid) {
+			t.Errorf("%d expected %d invalid parameters got %d: %s", i, len(v.invalid), len(d), res.Msg)
+		}
+
+		for _, k := range v.invalid {
+			if _, ok := d[k]; !ok {
+				t.Errorf("%d expected %s to be invalid: %s", i, k, res.Msg)
+			}
+		}
+	}
+
+	r, err := http.NewRequest("GET", "http://test.com/path;cache=busta", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ValidateQuery(r).Ok {
+		t.Error("expected false, cache busta")
+	}
+}
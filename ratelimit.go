@@ -0,0 +1,217 @@
+package weft
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TooManyRequests is for clients that have exceeded a rate limit.  See RateLimiter.
+var TooManyRequests = Result{Ok: false, Code: http.StatusTooManyRequests, Msg: "too many requests"}
+
+// RateLimitResult is the result of taking a token from a bucket in a RateLimitStore.
+type RateLimitResult struct {
+	Allowed    bool          // a token was taken.
+	Remaining  int           // tokens left in the bucket.
+	RetryAfter time.Duration // the time until a token is available when not Allowed.
+	Reset      time.Duration // the time until the bucket is full.
+}
+
+/*
+RateLimitStore keeps token buckets for a RateLimiter.  Implementations must be safe for concurrent
+use.  MemoryRateLimitStore keeps buckets in memory for a single process.  A shared store
+(e.g., in a database) allows a limit across processes.
+
+Take takes a token from the bucket for key at now.  Buckets hold up to burst tokens and
+are refilled at rate tokens per second.  A new bucket is full.
+*/
+type RateLimitStore interface {
+	Take(key string, rate float64, burst int, now time.Time) RateLimitResult
+}
+
+// MemoryRateLimitStore is a RateLimitStore in memory.  Use a store for each RateLimiter.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// pruneEvery is the number of takes between removing full buckets from a MemoryRateLimitStore.
+const pruneEvery = 4096
+
+// NewMemoryRateLimitStore returns an empty MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+}
+
+func (m *MemoryRateLimitStore) Take(key string, rate float64, burst int, now time.Time) RateLimitResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.takes++
+	if m.takes%pruneEvery == 0 {
+		m.prune(rate, burst, now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = refill(b, rate, burst, now)
+	b.last = now
+
+	var res RateLimitResult
+
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((float64(burst) - b.tokens) / rate)
+
+	return res
+}
+
+// prune removes buckets that are full at now.  They are the same as a new bucket.
+func (m *MemoryRateLimitStore) prune(rate float64, burst int, now time.Time) {
+	for k, b := range m.buckets {
+		if refill(b, rate, burst, now) >= float64(burst) {
+			delete(m.buckets, k)
+		}
+	}
+}
+
+// Len returns the number of buckets in m.
+func (m *MemoryRateLimitStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.buckets)
+}
+
+// refill returns the tokens in b at now.
+func refill(b *bucket, rate float64, burst int, now time.Time) float64 {
+	t := b.tokens + now.Sub(b.last).Seconds()*rate
+	return math.Min(t, float64(burst))
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+/*
+RateLimiter limits requests with a token bucket for each key returned by its key func.
+See ByIP, ByHeader and ByHandler.
+*/
+type RateLimiter struct {
+	rate  float64
+	burst int
+	key   func(r *http.Request) string
+	store RateLimitStore
+}
+
+/*
+NewRateLimiter returns a RateLimiter allowing bursts of up to burst requests for each key from key,
+refilled at rate requests per second.  Buckets are kept in a MemoryRateLimitStore unless store is
+not nil.
+
+NewRateLimiter panics if rate is not a finite number > 0 or burst is < 1.
+*/
+func NewRateLimiter(rate float64, burst int, key func(r *http.Request) string, store RateLimitStore) *RateLimiter {
+	if !(rate > 0) || math.IsInf(rate, 1) {
+		panic("weft: rate limit rate must be finite and > 0: " + strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+
+	if burst < 1 {
+		panic("weft: rate limit burst must be >= 1: " + strconv.Itoa(burst))
+	}
+
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+
+	return &RateLimiter{
+		rate:  rate,
+		burst: burst,
+		key:   key,
+		store: store,
+	}
+}
+
+/*
+Allow takes a token for r.  The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are
+set in h.  Returns &StatusOK if r is allowed otherwise &TooManyRequests with Retry-After set in h.
+*/
+func (l *RateLimiter) Allow(r *http.Request, h http.Header) *Result {
+	res := l.store.Take(l.key(r), l.rate, l.burst, time.Now())
+
+	h.Set("RateLimit-Limit", strconv.Itoa(l.burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+		return &TooManyRequests
+	}
+
+	return &StatusOK
+}
+
+// ceilSeconds returns d in whole seconds rounded up.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// RateLimit returns Middleware that limits requests with l.  The wrapped RequestHandler
+// is not called for requests over the limit.  Responses served from a ResponseCache
+// are not limited and don't have the RateLimit headers.
+func RateLimit(l *RateLimiter) Middleware {
+	return func(f RequestHandler) RequestHandler {
+		return func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+			if res := l.Allow(r, h); !res.Ok {
+				return res
+			}
+
+			return f(r, h, b)
+		}
+	}
+}
+
+// ByIP is a RateLimiter key func for the client IP address from the request RemoteAddr.
+// Behind a proxy or CDN use ByHeader with the header it sets for the client IP.
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// ByHeader returns a RateLimiter key func for the value of the request header name
+// e.g., an API key.  Requests without the header share a bucket.
+func ByHeader(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// ByHandler is a RateLimiter key func that shares one bucket between all requests.  Use a
+// RateLimiter for each handler to limit the total rate of requests to that handler.
+func ByHandler(r *http.Request) string {
+	return ""
+}
@@ -0,0 +1,172 @@
+package weft
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	m := NewMemoryRateLimitStore()
+	now := time.Date(2017, 5, 29, 10, 0, 0, 0, time.UTC)
+
+	// 2 tokens per second, burst of 3.
+	for i := 0; i < 3; i++ {
+		res := m.Take("a", 2, 3, now)
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Errorf("%d expected allowed with %d remaining got %+v", i, 2-i, res)
+		}
+	}
+
+	res := m.Take("a", 2, 3, now)
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond || res.Reset != 1500*time.Millisecond {
+		t.Errorf("expected denied with retry after 500ms got %+v", res)
+	}
+
+	if res = m.Take("b", 2, 3, now); !res.Allowed {
+		t.Error("expected separate bucket for b")
+	}
+
+	if res = m.Take("a", 2, 3, now.Add(500*time.Millisecond)); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("expected a token after 500ms got %+v", res)
+	}
+
+	m.prune(2, 3, now.Add(time.Hour))
+
+	if m.Len() != 0 {
+		t.Errorf("expected full buckets to be pruned got %d", m.Len())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	l := NewRateLimiter(0.1, 2, ByIP, nil)
+
+	f := MakeHandlerPage(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString("ok")
+		return &StatusOK
+	}, WithMiddleware(RateLimit(l)))
+
+	in := []struct {
+		remoteAddr string
+		code       int
+		remaining  string
+	}{
+		{remoteAddr: "192.0.2.1:1234", code: http.StatusOK, remaining: "1"},
+		{remoteAddr: "192.0.2.1:1235", code: http.StatusOK, remaining: "0"},
+		{remoteAddr: "192.0.2.1:1236", code: http.StatusTooManyRequests, remaining: "0"},
+		{remoteAddr: "192.0.2.2:1234", code: http.StatusOK, remaining: "1"},
+	}
+
+	for i, v := range in {
+		r := httptest.NewRequest("GET", "/quake", nil)
+		r.RemoteAddr = v.remoteAddr
+
+		w := httptest.NewRecorder()
+		f(w, r)
+
+		if w.Code != v.code {
+			t.Errorf("%d expected %d got %d", i, v.code, w.Code)
+		}
+
+		if w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != v.remaining {
+			t.Errorf("%d unexpected RateLimit headers %v", i, w.Header())
+		}
+
+		if v.code != http.StatusTooManyRequests {
+			continue
+		}
+
+		if w.Header().Get("Retry-After") != "10" {
+			t.Errorf("%d expected Retry-After 10 got %s", i, w.Header().Get("Retry-After"))
+		}
+
+		if w.Header().Get("Surrogate-Control") != "max-age=0" || w.Header().Get("Cache-Control") != "private, max-age=0" {
+			t.Errorf("%d expected 429 not to be cached got %v", i, w.Header())
+		}
+
+		if w.Body.String() != err429 {
+			t.Errorf("%d expected 429 error page", i)
+		}
+	}
+}
+
+// the RateLimit headers for one client are not served to others from a cache.
+func TestRateLimitCache(t *testing.T) {
+	l := NewRateLimiter(0.1, 5, ByIP, nil)
+	c := NewResponseCache(10, 0)
+
+	f := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		b.WriteString("ok")
+		return &StatusOK
+	}, WithCache(c), WithMiddleware(RateLimit(l)))
+
+	for i, addr := range []string{"192.0.2.1:1234", "192.0.2.2:1234"} {
+		r := httptest.NewRequest("GET", "/quake", nil)
+		r.RemoteAddr = addr
+
+		w := httptest.NewRecorder()
+		f(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%d expected 200 got %d", i, w.Code)
+		}
+
+		if i == 0 && w.Header().Get("RateLimit-Remaining") != "4" {
+			t.Errorf("%d expected RateLimit-Remaining 4 got %v", i, w.Header())
+		}
+
+		if i == 1 && w.Header().Get("RateLimit-Remaining") != "" {
+			t.Errorf("%d expected no RateLimit headers from the cache got %v", i, w.Header())
+		}
+	}
+
+	if c.Len() != 1 {
+		t.Errorf("expected 1 cache entry got %d", c.Len())
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	r := httptest.NewRequest("GET", "/quake", nil)
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	r.Header.Set("X-API-Key", "abc")
+
+	if k := ByIP(r); k != "2001:db8::1" {
+		t.Errorf("unexpected IP key %s", k)
+	}
+
+	if k := ByHeader("X-API-Key")(r); k != "abc" {
+		t.Errorf("unexpected header key %s", k)
+	}
+
+	if k := ByHandler(r); k != "" {
+		t.Errorf("unexpected handler key %s", k)
+	}
+}
+
+func TestNewRateLimiterPanics(t *testing.T) {
+	in := []struct {
+		rate  float64
+		burst int
+	}{
+		{rate: 0, burst: 1},
+		{rate: -1, burst: 1},
+		{rate: math.NaN(), burst: 1},
+		{rate: math.Inf(1), burst: 1},
+		{rate: 1, burst: 0},
+	}
+
+	for i, v := range in {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%d expected panic for rate %g burst %d", i, v.rate, v.burst)
+				}
+			}()
+
+			NewRateLimiter(v.rate, v.burst, ByIP, nil)
+		}()
+	}
+}
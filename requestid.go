@@ -0,0 +1,99 @@
+package weft
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const requestIDKey contextKey = "request-id"
+
+// requestIDHeader is the request and response header for request IDs.
+var requestIDHeader = "X-Request-ID"
+
+/*
+SetRequestIDHeader sets the header used to accept request IDs from clients (or proxies)
+and echo them in responses.  The default is X-Request-ID.
+
+SetRequestIDHeader should be called before serving requests.
+*/
+func SetRequestIDHeader(name string) {
+	requestIDHeader = http.CanonicalHeaderKey(name)
+}
+
+// WithRequestIDInErrors adds the request ID to error messages and problem+json written
+// by MakeHandlerAPI so that clients can quote it to support.
+// HTML error page templates can always use the request ID, see ErrorPage.
+func WithRequestIDInErrors() Option {
+	return func(h *handler) {
+		h.requestIDInErrors = true
+	}
+}
+
+// RequestID returns the request ID for r.  For requests not served by a weft handler
+// this is the request header if it is valid, otherwise empty.
+func RequestID(r *http.Request) string {
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		return id
+	}
+
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+
+	return ""
+}
+
+// RequestIDFromContext returns the request ID from ctx.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+/*
+withRequestID returns r with a request ID in its context.  The ID is from the request
+header if it is valid, otherwise a new ID is generated.  The ID is set on the response header.
+A request that already has an ID in its context keeps it.
+*/
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := RequestIDFromContext(r.Context())
+	if id == "" {
+		id = r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+	}
+
+	w.Header().Set(requestIDHeader, id)
+
+	return r
+}
+
+// newRequestID returns a random 128 bit request ID as hex.
+func newRequestID() string {
+	var p [16]byte
+	// crypto/rand.Read does not return errors.
+	rand.Read(p[:])
+	return hex.EncodeToString(p[:])
+}
+
+// validRequestID returns true if id is safe to log and echo to the client.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
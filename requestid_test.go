@@ -0,0 +1,95 @@
+package weft
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var id string
+
+	f := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		id = RequestID(r)
+		return &StatusOK
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test", nil)
+	r.Header.Set("X-Request-ID", "abc-123")
+	f(w, r)
+
+	if id != "abc-123" || w.Header().Get("X-Request-ID") != "abc-123" {
+		t.Errorf("expected request ID abc-123 got %s and %s", id, w.Header().Get("X-Request-ID"))
+	}
+
+	for _, v := range []string{"", "bad id", strings.Repeat("a", 129), "<script>"} {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("X-Request-ID", v)
+		f(w, r)
+
+		if id == v || len(id) != 32 || w.Header().Get("X-Request-ID") != id {
+			t.Errorf("expected generated request ID for %q got %s", v, id)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	f(w, httptest.NewRequest("GET", "/test", nil))
+	prev := id
+	f(w, httptest.NewRequest("GET", "/test", nil))
+
+	if id == prev {
+		t.Error("expected unique request IDs")
+	}
+}
+
+func TestRequestIDInErrors(t *testing.T) {
+	notFound := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return &NotFound
+	}
+
+	r := httptest.NewRequest("GET", "/test", nil)
+	r.Header.Set("X-Request-ID", "abc")
+
+	w := httptest.NewRecorder()
+	MakeHandlerAPI(notFound)(w, r)
+
+	if w.Body.String() != "not found" {
+		t.Errorf("expected no request ID by default got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	MakeHandlerAPI(notFound, WithRequestIDInErrors())(w, r)
+
+	if w.Body.String() != "not found (request id: abc)" {
+		t.Errorf("expected request ID in message got %s", w.Body.String())
+	}
+
+	r.Header.Set("Accept", "application/problem+json")
+	w = httptest.NewRecorder()
+	MakeHandlerAPI(notFound, WithRequestIDInErrors(), WithErrorFormat(ErrorProblemJSON))(w, r)
+
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.RequestID != "abc" {
+		t.Errorf("expected request ID abc got %s", p.RequestID)
+	}
+
+	RegisterErrorPage(http.StatusNotFound, template.Must(template.New("404").Parse(`{{.Code}} {{.RequestID}}`)))
+	defer RegisterErrorPage(http.StatusNotFound, nil)
+
+	w = httptest.NewRecorder()
+	MakeHandlerPage(notFound)(w, r)
+
+	if w.Body.String() != "404 abc" {
+		t.Errorf("expected request ID on error page got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,278 @@
+package weft
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// contextKey is the type for keys for values weft adds to request contexts.
+type contextKey string
+
+const paramsKey contextKey = "params"
+
+/*
+Router routes requests to handlers by method and path pattern e.g.,
+
+	r := weft.NewRouter()
+	r.API("GET", "/tag/{tag}", tagHandler)
+	r.API("PUT", "/tag/{tag}", tagPutHandler)
+	r.API("GET", "/files/{path...}", fileHandler)
+
+A pattern segment {name} matches any single non empty path segment.  A final
+segment {name...} matches the rest of the path.  Literal segments take precedence
+over {name} which takes precedence over {name...}.  Handlers get the matched values
+with PathParams.
+
+Requests for a path that matches but with a method that is not registered get
+MethodNotAllowed with an Allow header.  HEAD is served by the GET handler and OPTIONS
+is answered with an Allow header unless handlers are registered for them.
+Unmatched paths get NotFound.
+*/
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.Handler
+	page     bool // a Page handler is registered; write HTML error pages.
+}
+
+// Params are path parameters matched by a Router.
+type Params map[string]string
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// API registers f made with MakeHandlerAPI to serve requests for method and pattern.
+func (rt *Router) API(method, pattern string, f RequestHandler, opts ...Option) {
+	rt.handle(method, pattern, newHandler(f, name(f), false, opts), false)
+}
+
+// Page registers f made with MakeHandlerPage to serve requests for method and pattern.
+func (rt *Router) Page(method, pattern string, f RequestHandler, opts ...Option) {
+	rt.handle(method, pattern, newHandler(f, name(f), true, opts), true)
+}
+
+// Handle registers h to serve requests for method and pattern.  Panics if there
+// is already a handler for method and pattern or the pattern is not valid.
+func (rt *Router) Handle(method, pattern string, h http.Handler) {
+	rt.handle(method, pattern, h, false)
+}
+
+func (rt *Router) handle(method, pattern string, h http.Handler, page bool) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("weft: pattern must start with /: " + pattern)
+	}
+
+	segments := strings.Split(pattern, "/")[1:]
+
+	for i, s := range segments {
+		if strings.HasSuffix(s, "...}") && i != len(segments)-1 {
+			panic("weft: {name...} must be the last segment: " + pattern)
+		}
+	}
+
+	var rte *route
+
+	for _, v := range rt.routes {
+		if v.pattern == pattern {
+			rte = v
+			break
+		}
+	}
+
+	if rte == nil {
+		rte = &route{
+			pattern:  pattern,
+			segments: segments,
+			handlers: make(map[string]http.Handler),
+		}
+		rt.routes = append(rt.routes, rte)
+	}
+
+	if _, ok := rte.handlers[method]; ok {
+		panic("weft: multiple registrations for " + method + " " + pattern)
+	}
+
+	rte.handlers[method] = h
+	rte.page = rte.page || page
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var best *route
+	var params Params
+
+	path := strings.Split(r.URL.Path, "/")[1:]
+
+	for _, v := range rt.routes {
+		if p, ok := v.match(path); ok && (best == nil || v.moreSpecific(best)) {
+			best = v
+			params = p
+		}
+	}
+
+	if best == nil {
+		notFoundAPI.ServeHTTP(w, r)
+		return
+	}
+
+	if len(params) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), paramsKey, params))
+	}
+
+	h, ok := best.handlers[r.Method]
+	if !ok && r.Method == "HEAD" {
+		h, ok = best.handlers["GET"]
+	}
+
+	if ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Allow", best.allow())
+
+	switch {
+	case r.Method == "OPTIONS":
+		optionsAPI.ServeHTTP(w, r)
+	case best.page:
+		methodNotAllowedPage.ServeHTTP(w, r)
+	default:
+		methodNotAllowedAPI.ServeHTTP(w, r)
+	}
+}
+
+// match returns the Params if path (the request path split on "/") matches v.
+func (v *route) match(path []string) (Params, bool) {
+	var p Params
+
+	for i, s := range v.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "...}") {
+			if p == nil {
+				p = make(Params)
+			}
+			p[s[1:len(s)-4]] = strings.Join(path[i:], "/")
+			return p, true
+		}
+
+		if i >= len(path) {
+			return nil, false
+		}
+
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if path[i] == "" {
+				return nil, false
+			}
+			if p == nil {
+				p = make(Params)
+			}
+			p[s[1:len(s)-1]] = path[i]
+			continue
+		}
+
+		if s != path[i] {
+			return nil, false
+		}
+	}
+
+	return p, len(path) == len(v.segments)
+}
+
+// moreSpecific returns true if v should be used instead of o when both match a path.
+func (v *route) moreSpecific(o *route) bool {
+	for i := 0; i < len(v.segments) && i < len(o.segments); i++ {
+		a, b := segmentKind(v.segments[i]), segmentKind(o.segments[i])
+		if a != b {
+			return a < b
+		}
+	}
+
+	return len(v.segments) > len(o.segments)
+}
+
+// segmentKind orders pattern segments by precedence; literals, {name}, {name...}.
+func segmentKind(s string) int {
+	switch {
+	case !strings.HasPrefix(s, "{"):
+		return 0
+	case strings.HasSuffix(s, "...}"):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// allow returns the Allow header value for v.
+func (v *route) allow() string {
+	m := map[string]bool{"OPTIONS": true}
+
+	for k := range v.handlers {
+		m[k] = true
+	}
+
+	if m["GET"] {
+		m["HEAD"] = true
+	}
+
+	var a []string
+	for k := range m {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+
+	return strings.Join(a, ", ")
+}
+
+var (
+	notFoundAPI          = MakeHandlerAPI(notFound)
+	methodNotAllowedAPI  = MakeHandlerAPI(methodNotAllowed)
+	methodNotAllowedPage = MakeHandlerPage(methodNotAllowed)
+	optionsAPI           = MakeHandlerAPI(options)
+)
+
+func notFound(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+	return &NotFound
+}
+
+func methodNotAllowed(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+	return &MethodNotAllowed
+}
+
+func options(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+	return &StatusOK
+}
+
+// PathParams returns the path parameters matched by a Router for r.
+func PathParams(r *http.Request) Params {
+	p, _ := r.Context().Value(paramsKey).(Params)
+	return p
+}
+
+// Get returns the value of the parameter name or "" if it was not matched.
+func (p Params) Get(name string) string {
+	return p[name]
+}
+
+// Int returns the value of the parameter name as an int.
+func (p Params) Int(name string) (int, error) {
+	return strconv.Atoi(p[name])
+}
+
+// Float64 returns the value of the parameter name as a float64.
+func (p Params) Float64(name string) (float64, error) {
+	return strconv.ParseFloat(p[name], 64)
+}
+
+// Time returns the value of the parameter name parsed as an RFC3339 time.
+func (p Params) Time(name string) (time.Time, error) {
+	return time.Parse(time.RFC3339, p[name])
+}
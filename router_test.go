@@ -0,0 +1,122 @@
+package weft
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	handler := func(id string) RequestHandler {
+		return func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+			b.WriteString(id)
+			for _, k := range []string{"tag", "id", "path"} {
+				if v := PathParams(r).Get(k); v != "" {
+					b.WriteString(" " + k + "=" + v)
+				}
+			}
+			return &StatusOK
+		}
+	}
+
+	rt := NewRouter()
+	rt.API("GET", "/tag/{tag}", handler("tag"))
+	rt.API("PUT", "/tag/{tag}", handler("tag put"))
+	rt.API("GET", "/tag/latest", handler("latest"))
+	rt.API("GET", "/tag", handler("tags"))
+	rt.API("GET", "/quake/{id}/history", handler("history"))
+	rt.Page("GET", "/files/{path...}", handler("files"))
+	rt.Handle("GET", "/plain", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	}))
+
+	in := []struct {
+		method, url string
+		code        int
+		body        string
+		allow       string
+	}{
+		{method: "GET", url: "/tag/t1", code: http.StatusOK, body: "tag tag=t1"},
+		{method: "PUT", url: "/tag/t1", code: http.StatusOK, body: "tag put tag=t1"},
+		{method: "GET", url: "/tag/latest", code: http.StatusOK, body: "latest"},
+		{method: "GET", url: "/tag", code: http.StatusOK, body: "tags"},
+		{method: "GET", url: "/tag/", code: http.StatusNotFound, body: "not found"},
+		{method: "GET", url: "/tag/t1/extra", code: http.StatusNotFound, body: "not found"},
+		{method: "GET", url: "/quake/2017p123456/history", code: http.StatusOK, body: "history id=2017p123456"},
+		{method: "GET", url: "/files/a/b/c.csv", code: http.StatusOK, body: "files path=a/b/c.csv"},
+		{method: "GET", url: "/plain", code: http.StatusOK, body: "plain"},
+		// the server discards the body for HEAD, the recorder doesn't.
+		{method: "HEAD", url: "/tag/t1", code: http.StatusOK, body: "tag tag=t1"},
+		{method: "DELETE", url: "/tag/t1", code: http.StatusMethodNotAllowed, body: "method not allowed", allow: "GET, HEAD, OPTIONS, PUT"},
+		{method: "DELETE", url: "/files/a", code: http.StatusMethodNotAllowed, body: err405, allow: "GET, HEAD, OPTIONS"},
+		{method: "OPTIONS", url: "/tag/t1", code: http.StatusOK, allow: "GET, HEAD, OPTIONS, PUT"},
+	}
+
+	for i, v := range in {
+		r, err := http.NewRequest(v.method, "http://test.com"+v.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+
+		if w.Code != v.code {
+			t.Errorf("%d %s %s expected status %d got %d", i, v.method, v.url, v.code, w.Code)
+		}
+
+		if w.Body.String() != v.body {
+			t.Errorf("%d %s %s expected body %s got %s", i, v.method, v.url, v.body, w.Body.String())
+		}
+
+		if w.Header().Get("Allow") != v.allow {
+			t.Errorf("%d %s %s expected Allow %s got %s", i, v.method, v.url, v.allow, w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestRouterPanics(t *testing.T) {
+	f := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return &StatusOK
+	}
+
+	for _, p := range []string{"tag", "/files/{path...}/extra", "/dup"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for %s", p)
+				}
+			}()
+
+			rt := NewRouter()
+			rt.API("GET", "/dup", f)
+			rt.API("GET", p, f)
+		}()
+	}
+}
+
+func TestParams(t *testing.T) {
+	p := Params{"id": "42", "lat": "-41.5", "time": "2017-05-29T10:00:00Z", "bad": "x"}
+
+	if i, err := p.Int("id"); err != nil || i != 42 {
+		t.Errorf("expected 42 got %d %v", i, err)
+	}
+
+	if f, err := p.Float64("lat"); err != nil || f != -41.5 {
+		t.Errorf("expected -41.5 got %f %v", f, err)
+	}
+
+	if tm, err := p.Time("time"); err != nil || tm.Year() != 2017 {
+		t.Errorf("expected 2017 got %v %v", tm, err)
+	}
+
+	if _, err := p.Int("bad"); err == nil {
+		t.Error("expected error for bad int")
+	}
+
+	var r http.Request
+	if PathParams(&r).Get("id") != "" {
+		t.Error("expected no params")
+	}
+}
@@ -0,0 +1,99 @@
+package weft
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// latencyBudget is the default time a handler may take before the request is logged as slow.
+var latencyBudget = 250 * time.Millisecond
+
+/*
+SetLatencyBudget sets the default time a RequestHandler may take before the request is
+logged as slow.  The default is 250 ms.  Use WithLatencyBudget for individual handlers.
+Zero or less disables the slow request log.
+
+SetLatencyBudget should be called before serving requests.
+*/
+func SetLatencyBudget(d time.Duration) {
+	latencyBudget = d
+}
+
+// WithLatencyBudget sets the time the handler may take before the request is logged as slow.
+// A negative d disables the slow request log for the handler.
+func WithLatencyBudget(d time.Duration) Option {
+	return func(h *handler) {
+		h.latencyBudget = d
+	}
+}
+
+/*
+Timing is the time taken to serve a request.
+
+Handle is the time taken by the RequestHandler and any Middleware.  For MakeSimpleHandler this
+includes writing to the client.  Write is the time taken writing the response to the client,
+including compression.  A response served from a ResponseCache has only Write.
+*/
+type Timing struct {
+	Handler string        // the handler name.
+	Method  string        // the request method.
+	Code    int           // the status code written to the client.
+	Handle  time.Duration // time in the handler.
+	Write   time.Duration // time compressing and writing the response.
+	Budget  time.Duration // the latency budget for the handler.
+}
+
+// Total returns the total time taken to serve the request.
+func (t Timing) Total() time.Duration {
+	return t.Handle + t.Write
+}
+
+// Slow returns true if the handler took longer than its latency budget.
+func (t Timing) Slow() bool {
+	return t.Budget > 0 && t.Handle > t.Budget
+}
+
+// timingHandler is called with the Timing for every request.
+var timingHandler struct {
+	sync.RWMutex
+	f func(r *http.Request, t Timing)
+}
+
+/*
+SetTimingHandler sets f to be called with the Timing for every request served by a handler
+made with MakeHandlerPage, MakeHandlerAPI or MakeSimpleHandler e.g., to alert when
+t.Slow().  f is called after the response has been written.  f should not block for long.
+*/
+func SetTimingHandler(f func(r *http.Request, t Timing)) {
+	timingHandler.Lock()
+	defer timingHandler.Unlock()
+
+	timingHandler.f = f
+}
+
+// budget returns the latency budget for h.
+func (h *handler) budget() time.Duration {
+	if h.latencyBudget != 0 {
+		return h.latencyBudget
+	}
+
+	return latencyBudget
+}
+
+// report logs slow requests and calls the timing handler.
+func (t Timing) report(r *http.Request) {
+	if t.Slow() {
+		log.Printf("slow: took %d ms serving %s request %s (handler %d ms, write %d ms, budget %d ms)",
+			t.Total()/time.Millisecond, r.RequestURI, RequestID(r), t.Handle/time.Millisecond, t.Write/time.Millisecond, t.Budget/time.Millisecond)
+	}
+
+	timingHandler.RLock()
+	f := timingHandler.f
+	timingHandler.RUnlock()
+
+	if f != nil {
+		f(r, t)
+	}
+}
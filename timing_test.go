@@ -0,0 +1,83 @@
+package weft
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimingHandler(t *testing.T) {
+	var mu sync.Mutex
+	var timings []Timing
+
+	SetTimingHandler(func(r *http.Request, t Timing) {
+		mu.Lock()
+		defer mu.Unlock()
+		timings = append(timings, t)
+	})
+	defer SetTimingHandler(nil)
+
+	slow := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		time.Sleep(20 * time.Millisecond)
+		b.WriteString("slow")
+		return &StatusOK
+	}, WithLatencyBudget(10*time.Millisecond))
+
+	fast := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return &NotFound
+	})
+
+	slow(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	fast(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast", nil))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(timings) != 2 {
+		t.Fatalf("expected 2 timings got %d", len(timings))
+	}
+
+	s := timings[0]
+
+	if s.Handler != "func2" || s.Method != "GET" || s.Code != http.StatusOK {
+		t.Errorf("unexpected timing %+v", s)
+	}
+
+	if s.Budget != 10*time.Millisecond {
+		t.Errorf("expected budget 10ms got %s", s.Budget)
+	}
+
+	if s.Handle < 20*time.Millisecond || !s.Slow() {
+		t.Errorf("expected slow handler got %s", s.Handle)
+	}
+
+	if s.Total() != s.Handle+s.Write {
+		t.Error("expected total to be handle + write")
+	}
+
+	f := timings[1]
+
+	if f.Code != http.StatusNotFound || f.Budget != 250*time.Millisecond || f.Slow() {
+		t.Errorf("unexpected timing %+v", f)
+	}
+}
+
+func TestTimingSlow(t *testing.T) {
+	in := []struct {
+		t    Timing
+		slow bool
+	}{
+		{t: Timing{Handle: time.Second, Budget: 250 * time.Millisecond}, slow: true},
+		{t: Timing{Handle: time.Millisecond, Write: time.Second, Budget: 250 * time.Millisecond}, slow: false},
+		{t: Timing{Handle: time.Second, Budget: -1}, slow: false},
+	}
+
+	for i, v := range in {
+		if v.t.Slow() != v.slow {
+			t.Errorf("%d expected slow %t", i, v.slow)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package weft
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spanKey contextKey = "span"
+
+// SpanContext identifies a span in a trace.  It is propagated between services with
+// the W3C Trace Context traceparent header.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid returns true if c has non zero trace and span IDs.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+// TraceParent returns c as a traceparent header value e.g.,
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func (c SpanContext) TraceParent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + hex.EncodeToString(c.TraceID[:]) + "-" + hex.EncodeToString(c.SpanID[:]) + "-" + flags
+}
+
+var errTraceParent = errors.New("invalid traceparent")
+
+// ParseTraceParent parses a W3C Trace Context traceparent header value.
+func ParseTraceParent(s string) (SpanContext, error) {
+	var c SpanContext
+
+	p := strings.Split(strings.TrimSpace(s), "-")
+	if len(p) < 4 || len(p[0]) != 2 || p[0] == "ff" || (p[0] == "00" && len(p) != 4) {
+		return c, errTraceParent
+	}
+
+	if len(p[1]) != 32 || len(p[2]) != 16 || len(p[3]) != 2 || !isLowerHex(p[0]+p[1]+p[2]+p[3]) {
+		return c, errTraceParent
+	}
+
+	var flags [1]byte
+
+	hex.Decode(c.TraceID[:], []byte(p[1]))
+	hex.Decode(c.SpanID[:], []byte(p[2]))
+	hex.Decode(flags[:], []byte(p[3]))
+
+	c.Sampled = flags[0]&1 == 1
+
+	if !c.IsValid() {
+		return SpanContext{}, errTraceParent
+	}
+
+	return c, nil
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Span is a timed operation in a trace.  Handlers made with MakeHandlerPage, MakeHandlerAPI and
+MakeSimpleHandler start a server span named for the handler.  Use SpanFromContext to add attributes
+to it and StartSpan for child spans.
+
+Span is a small stand-in for an OpenTelemetry span so weft has no dependency on the
+OpenTelemetry SDK.  Attributes follow the OpenTelemetry semantic conventions for HTTP and
+OTLPExporter sends spans to OpenTelemetry tooling.
+
+The methods on Span are safe for concurrent use and do nothing for a nil Span.  Attributes
+set after Finish are dropped e.g., from a handler still running after WithTimeout.
+The fields should only be read after Finish, e.g., by a SpanExporter.
+*/
+type Span struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanContext // zero for a root span.
+	Start      time.Time
+	End        time.Time
+	Error      bool // the operation failed.
+	Attributes map[string]interface{}
+
+	mu     sync.Mutex
+	ended  bool
+	server bool // started for a request by a handler.
+}
+
+// SpanExporter receives spans when they end.  Implementations must be safe for concurrent use.
+type SpanExporter interface {
+	Export(s *Span)
+}
+
+var spanExporter struct {
+	sync.RWMutex
+	e SpanExporter
+}
+
+/*
+SetSpanExporter sets e to receive sampled spans when they end.  Tracing is disabled
+when there is no SpanExporter, which is the default.
+
+A request with a valid traceparent header continues that trace and is sampled
+if the caller sampled it.  Other requests start a new, sampled, trace.
+*/
+func SetSpanExporter(e SpanExporter) {
+	spanExporter.Lock()
+	defer spanExporter.Unlock()
+
+	spanExporter.e = e
+}
+
+func exporter() SpanExporter {
+	spanExporter.RLock()
+	defer spanExporter.RUnlock()
+
+	return spanExporter.e
+}
+
+// SpanFromContext returns the current Span from ctx or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// StartSpan starts a Span that is a child of the Span in ctx.  The Span is added to the returned
+// context.  Call Finish on the Span when the operation is done.  Returns ctx and a nil Span when tracing
+// is disabled.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if exporter() == nil {
+		return ctx, nil
+	}
+
+	var parent SpanContext
+	sampled := true
+
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.Context
+		sampled = parent.Sampled
+	}
+
+	s := newSpan(name, parent, sampled)
+
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+func newSpan(name string, parent SpanContext, sampled bool) *Span {
+	s := &Span{
+		Name:       name,
+		Parent:     parent,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+
+	s.Context.TraceID = parent.TraceID
+	if !parent.IsValid() {
+		rand.Read(s.Context.TraceID[:])
+	}
+
+	rand.Read(s.Context.SpanID[:])
+	s.Context.Sampled = sampled
+
+	return s
+}
+
+// SetAttribute sets the attribute k to v on s.
+func (s *Span) SetAttribute(k string, v interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.Attributes[k] = v
+}
+
+// SetError marks the operation for s as failed with msg.
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.Error = true
+	if msg != "" {
+		s.Attributes["error.message"] = msg
+	}
+}
+
+// TraceParent returns the traceparent header value for calls made as part of s.
+// Returns an empty string for a nil s.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return s.Context.TraceParent()
+}
+
+// Finish ends s and exports it if sampled.  Calls after the first do nothing.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if e := exporter(); e != nil && s.Context.Sampled {
+		e.Export(s)
+	}
+}
+
+// startServerSpan starts the server Span named for handler for r.  Returns r with
+// the Span in its context.  The Span is nil if tracing is disabled.
+func startServerSpan(r *http.Request, handler string) (*http.Request, *Span) {
+	if exporter() == nil {
+		return r, nil
+	}
+
+	parent, err := ParseTraceParent(r.Header.Get("traceparent"))
+	sampled := err != nil || parent.Sampled
+
+	s := newSpan(handler, parent, sampled)
+	s.server = true
+
+	s.Attributes["http.request.method"] = r.Method
+	s.Attributes["url.path"] = r.URL.Path
+	if r.URL.RawQuery != "" {
+		s.Attributes["url.query"] = r.URL.RawQuery
+	}
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		s.Attributes["weft.request_id"] = id
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), spanKey, s)), s
+}
+
+// finishServerSpan records the response written to w for res on s and ends it.
+func finishServerSpan(s *Span, w *statusWriter, res *Result, code int) {
+	if s == nil {
+		return
+	}
+
+	s.SetAttribute("http.response.status_code", code)
+	s.SetAttribute("http.response.body.size", w.n)
+	if e := w.Header().Get("Content-Encoding"); e != "" {
+		s.SetAttribute("http.response.content_encoding", e)
+	}
+
+	if code >= 500 {
+		s.SetError(res.Msg)
+	} else if res.Code != http.StatusOK && res.Msg != "" {
+		s.SetAttribute("weft.result.msg", res.Msg)
+	}
+
+	s.Finish()
+}
+
+// MemoryExporter is a SpanExporter that keeps spans in memory e.g., for tests.
+// The zero value is ready to use.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (m *MemoryExporter) Export(s *Span) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.spans = append(m.spans, s)
+}
+
+// Spans returns the exported spans in the order they ended.
+func (m *MemoryExporter) Spans() []*Span {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := make([]*Span, len(m.spans))
+	copy(s, m.spans)
+
+	return s
+}
+
+// Reset discards the exported spans.
+func (m *MemoryExporter) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.spans = nil
+}
@@ -0,0 +1,271 @@
+package weft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	in := []struct {
+		s     string
+		valid bool
+	}{
+		{s: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", valid: true},
+		{s: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", valid: true},
+		{s: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", valid: true},
+		{s: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{s: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{s: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{s: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{s: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{s: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"},
+		{s: ""},
+	}
+
+	for i, v := range in {
+		c, err := ParseTraceParent(v.s)
+		if v.valid != (err == nil) {
+			t.Errorf("%d expected valid %t for %s", i, v.valid, v.s)
+		}
+
+		if v.valid && strings.HasPrefix(v.s, "00") && c.TraceParent() != v.s {
+			t.Errorf("%d expected %s got %s", i, v.s, c.TraceParent())
+		}
+	}
+}
+
+func TestServerSpan(t *testing.T) {
+	var m MemoryExporter
+	SetSpanExporter(&m)
+	defer SetSpanExporter(nil)
+
+	quake := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		SpanFromContext(r.Context()).SetAttribute("quake.id", "2017p123456")
+
+		_, s := StartSpan(r.Context(), "db")
+		s.Finish()
+
+		h.Set("Content-Type", "application/json")
+		b.WriteString(strings.Repeat(`{"quake": "2017p123456"}`, 10))
+		return &StatusOK
+	}
+
+	broken := func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		return InternalServerError(errors.New("database down"))
+	}
+
+	r := httptest.NewRequest("GET", "/quake?id=1", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set("Accept-Encoding", "gzip")
+	MakeHandlerAPI(quake)(httptest.NewRecorder(), r)
+
+	s := m.Spans()
+	if len(s) != 2 {
+		t.Fatalf("expected 2 spans got %d", len(s))
+	}
+
+	db, server := s[0], s[1]
+
+	if server.Name != "func1" || db.Name != "db" {
+		t.Errorf("unexpected span names %s %s", server.Name, db.Name)
+	}
+
+	if server.Parent.TraceParent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("expected remote parent got %s", server.Parent.TraceParent())
+	}
+
+	if server.Context.TraceID != server.Parent.TraceID || db.Context.TraceID != server.Context.TraceID || db.Parent != server.Context {
+		t.Error("expected spans in the same trace")
+	}
+
+	for k, v := range map[string]interface{}{
+		"http.request.method":            "GET",
+		"url.path":                       "/quake",
+		"url.query":                      "id=1",
+		"http.response.status_code":      http.StatusOK,
+		"http.response.content_encoding": "gzip",
+		"quake.id":                       "2017p123456",
+	} {
+		if server.Attributes[k] != v {
+			t.Errorf("expected %s=%v got %v", k, v, server.Attributes[k])
+		}
+	}
+
+	if n, ok := server.Attributes["http.response.body.size"].(int64); !ok || n == 0 {
+		t.Error("expected response body size")
+	}
+
+	if server.Error || server.End.Before(server.Start) {
+		t.Error("unexpected server span")
+	}
+
+	m.Reset()
+
+	// no traceparent starts a new trace.  Unsampled traces are not exported.
+	MakeHandlerAPI(broken)(httptest.NewRecorder(), httptest.NewRequest("GET", "/broken", nil))
+
+	r = httptest.NewRequest("GET", "/broken", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	MakeHandlerAPI(broken)(httptest.NewRecorder(), r)
+
+	s = m.Spans()
+	if len(s) != 1 {
+		t.Fatalf("expected 1 span got %d", len(s))
+	}
+
+	if s[0].Parent.IsValid() || !s[0].Context.IsValid() {
+		t.Error("expected a root span")
+	}
+
+	if !s[0].Error || s[0].Attributes["error.message"] != "database down" || s[0].Attributes["http.response.status_code"] != 500 {
+		t.Errorf("expected error span got %+v", s[0].Attributes)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	f := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		s := SpanFromContext(r.Context())
+		if s != nil {
+			t.Error("expected no span")
+		}
+		s.SetAttribute("ignored", true)
+
+		_, c := StartSpan(r.Context(), "child")
+		c.Finish()
+
+		return &StatusOK
+	})
+
+	f(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+}
+
+// TestSpanAfterTimeout checks a handler still running after WithTimeout can't
+// change its span once it has been exported.  Run with -race.
+func TestSpanAfterTimeout(t *testing.T) {
+	var m MemoryExporter
+	SetSpanExporter(&m)
+	defer SetSpanExporter(nil)
+
+	served := make(chan bool)
+	done := make(chan bool)
+
+	slow := func(ctx context.Context, r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		defer close(done)
+
+		<-served
+
+		s := SpanFromContext(ctx)
+		for i := 0; i < 100; i++ {
+			s.SetAttribute("late", i)
+			s.SetError("late")
+		}
+
+		return &StatusOK
+	}
+
+	w := httptest.NewRecorder()
+	MakeHandlerAPIContext(slow, WithTimeout(time.Millisecond))(w, httptest.NewRequest("GET", "/slow", nil))
+	close(served)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 got %d", w.Code)
+	}
+
+	s := m.Spans()
+	if len(s) != 1 {
+		t.Fatalf("expected 1 span got %d", len(s))
+	}
+
+	// read the exported span while the handler is still running.
+	for {
+		for range s[0].Attributes {
+		}
+
+		select {
+		case <-done:
+			if _, ok := s[0].Attributes["late"]; ok || s[0].Attributes["error.message"] == "late" {
+				t.Error("expected attributes set after Finish to be dropped")
+			}
+			return
+		default:
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var b bytes.Buffer
+	SetSpanExporter(NewOTLPExporter(&b, "quake-ws"))
+	defer SetSpanExporter(nil)
+
+	f := MakeHandlerAPI(func(r *http.Request, h http.Header, b *bytes.Buffer) *Result {
+		_, s := StartSpan(r.Context(), "db")
+		s.SetAttribute("db.rows", 3)
+		s.Finish()
+
+		return InternalServerError(errors.New("database down"))
+	})
+
+	r := httptest.NewRequest("GET", "/quake", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f(httptest.NewRecorder(), r)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines got %d:\n%s", len(lines), b.String())
+	}
+
+	var spans []map[string]interface{}
+
+	for _, l := range lines {
+		var req struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []map[string]interface{}
+				}
+				ScopeSpans []struct {
+					Spans []map[string]interface{}
+				}
+			}
+		}
+
+		if err := json.Unmarshal([]byte(l), &req); err != nil {
+			t.Fatal(err)
+		}
+
+		rs := req.ResourceSpans[0]
+		if rs.Resource.Attributes[0]["key"] != "service.name" {
+			t.Errorf("expected service.name resource attribute in %s", l)
+		}
+
+		spans = append(spans, rs.ScopeSpans[0].Spans[0])
+	}
+
+	db, server := spans[0], spans[1]
+
+	if server["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || db["traceId"] != server["traceId"] {
+		t.Errorf("expected spans in the remote trace got %v %v", server["traceId"], db["traceId"])
+	}
+
+	if server["parentSpanId"] != "00f067aa0ba902b7" || db["parentSpanId"] != server["spanId"] {
+		t.Error("expected db span to be a child of the server span")
+	}
+
+	if server["kind"] != 2.0 || db["kind"] != 1.0 {
+		t.Errorf("expected server and internal kinds got %v %v", server["kind"], db["kind"])
+	}
+
+	if s, ok := server["status"].(map[string]interface{}); !ok || s["code"] != 2.0 || s["message"] != "database down" {
+		t.Errorf("expected error status got %v", server["status"])
+	}
+
+	if !strings.Contains(lines[0], `{"key":"db.rows","value":{"intValue":"3"}}`) {
+		t.Errorf("expected int attribute in %s", lines[0])
+	}
+}
@@ -1,9 +1,8 @@
 /*
 weft helps with web applications.
 
-There are build tags to give extra log output in devmode e.g.,
-
-go build -tags devmode ...
+Requests with errors are written to the access log.  Use SetLogLevel(LevelInfo) for every
+request and SetLogger to change the output e.g., to JSON or logfmt.
 */
 package weft
 
@@ -26,10 +25,12 @@ var (
 )
 
 type Result struct {
-	Ok       bool   // set true to indicate success
-	Code     int    // http status code for writing back to the client e.g., http.StatusOK for success.
-	Msg      string // any error message for logging or to send to the client.
-	Redirect string // a URL to redirect to.  Use with Code = 3xx.
+	Ok       bool        // set true to indicate success
+	Code     int         // http status code for writing back to the client e.g., http.StatusOK for success.
+	Msg      string      // any error message for logging or to send to the client.
+	Redirect string      // a URL to redirect to.  Use with Code = 3xx.
+	Type     string      // optional machine readable error type e.g., "invalid-time".  A URI reference for problem+json.
+	Details  interface{} // optional extra details for an error.  Written with problem+json so must marshal to JSON.
 }
 
 type RequestHandler func(r *http.Request, h http.Header, b *bytes.Buffer) *Result
@@ -116,7 +117,8 @@ func name(f interface{}) string {
 	return n
 }
 
-// count increments mtr counters for Result.
+// Count increments mtr counters for Result.  Only 200, 400, 401, 404, 500 and 503 have
+// counters in mtrapp.  MtrMetrics also counts every status code by handler, see MtrMetrics.
 func (r *Result) Count() {
 	if r != nil && r.Code != 0 {
 		mtrapp.Requests.Inc()
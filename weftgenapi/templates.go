@@ -184,8 +184,17 @@ const (
 	<h3 class="page-header">Compression</h3>
 
 	<p>The response for a query can be compressed.  If your client can handle a compressed response then the
-	reduced download size is a great benifit.  Gzip compression is supported.  You can request a compressed response
-	by including <code>gzip</code> in your <code>Accept-Encoding</code> header.</p>
+	reduced download size is a great benifit.  Brotli, zstd, gzip and deflate compression are supported.  You can request a compressed response
+	by including <code>br</code>, <code>zstd</code>, <code>gzip</code> or <code>deflate</code> in your <code>Accept-Encoding</code> header.  Preferences
+	may be given with q-values e.g., <code>zstd;q=1.0, gzip;q=0.5</code>.</p>
+
+	{{if .HasAuth}}
+	<h3 class="page-header">Authorization</h3>
+
+	<p>Some requests require authorization and are marked below, with the roles that may make them.
+	Requests without valid credentials get a <code>401</code> response with a <code>WWW-Authenticate</code> header
+	describing the credentials to use.  Requests with credentials that don't have one of the roles get a <code>403</code> response.</p>
+	{{end}}
 
 	<h3 class="page-header">Bugs</h3>
 
@@ -208,6 +217,7 @@ const (
 	<dt>URI</dt><dd>{{.Uri}}{{if .P.Id}}({{.P.Id}}){{end}}</dd>
 	{{if .Accept}}<dt>Accept</dt><dd>{{.Accept}}</dd>{{end}}
 	{{if .Default}}<dt>Default</dt><dd>default for GET with unmatched Accept.</dd>{{end}}
+	{{if .Auth}}<dt>Authorization</dt><dd>required{{if .Roles}} with role: {{range $i, $r := .Roles}}{{if $i}} or {{end}}<code>{{$r}}</code>{{end}}{{end}}</dd>{{end}}
 	</dl>
 	</div>
 	</div>
@@ -1,5 +1,11 @@
 // weftgen generates http handler wiring with Accept header routing from a TOML file.
-// weft.CheckQuery(...) is added based on the Required and Optional query parameters.
+// weft.ValidateQuery(...) is added based on the Required and Optional query parameters.
+// Query parameter values are validated based on their Type e.g., int, float64, time (RFC3339) or bbox.
+// Requests with Auth or Roles are authorized with weft.Authenticate before the query is checked.  The
+// package with the generated handlers must declare the weft.Authenticator e.g.,
+//
+//	var authenticator weft.Authenticator = weft.NewBasicAuth(...)
+//
 // The Content-Type for the response is set based on the Accept header.
 //
 // HTML docs are also generated (and a handler to serve them). They are available at http://.../api-docs
@@ -33,10 +39,23 @@ type api struct {
 type parameter struct {
 	Id          string // defaults to the map[string] if zero.
 	Description string // a description of the parameter.  Can include HTML, does not need surrounding tags.
-	Type        string // the type of the parameter e.g., int32
+	Type        string // the type of the parameter e.g., int32.  Used to validate query parameters.
 	// TODO include a list of possible values?  Should this just be a slice of strings?
 }
 
+// HasAuth returns true if any request requires authorization.  For use in HTML templates.
+func (a api) HasAuth() bool {
+	for _, e := range a.Endpoint {
+		for _, r := range e.Request {
+			if r.Auth {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 type endpoint struct {
 	Uri         string
 	Request     Request // allow multiple GET requests routed by Accept.  Only 1 PUT or DELETE.
@@ -57,6 +76,8 @@ type request struct {
 	Group       string   // should match the string in api.Parameter[string]
 	Description string   // a short description for the request.  Can include HTML, does not need surrounding tags.
 	Discussion  string   // any extended discussion for request or response.  Can include HTML and requires surround <p> tags.
+	Auth        bool     // the request must be authenticated.  Set when there are Roles.
+	Roles       []string // the client must have one of these roles.
 
 	// the following members do not need to be added to the TOML.  They are for use in HTML templates.
 	R   Parameter // query parameters added based on Required and api.Parameter.
@@ -141,28 +162,73 @@ func handlerName(f string) string {
 	return strings.Replace(f, "/", "", -1) + "Handler"
 }
 
-// check writes a checkQuery func to b
+// authorize writes a weft.Authenticate check to b if the request requires authorization.
+// r is replaced with the authenticated request so the handler can use weft.RequestPrincipal.
+func (a request) authorize(b *bytes.Buffer) {
+	if !a.Auth {
+		return
+	}
+
+	b.WriteString("var res *weft.Result\n")
+	b.WriteString("if r, res = weft.Authenticate(r, h, authenticator")
+
+	for _, v := range a.Roles {
+		b.WriteString(fmt.Sprintf(", %q", v))
+	}
+
+	b.WriteString("); !res.Ok {\n")
+	b.WriteString("return res\n")
+	b.WriteString("}\n")
+}
+
+// check writes a weft.ValidateQuery func to b.  Query parameter values are validated
+// based on their Type.
 func (a request) checkQuery(b *bytes.Buffer) {
-	b.WriteString(fmt.Sprintf("if res := weft.CheckQuery(r, []string{%s}, []string{%s}); !res.Ok {\n",
-		a.R.checkString(), a.O.checkString()))
+	b.WriteString("if res := weft.ValidateQuery(r")
+
+	for _, v := range a.R {
+		b.WriteString(",\n" + v.queryParam(true))
+	}
+
+	for _, v := range a.O {
+		b.WriteString(",\n" + v.queryParam(false))
+	}
+
+	b.WriteString("); !res.Ok {\n")
 	b.WriteString("return res\n")
 	b.WriteString("}\n")
 }
 
-func (a Parameter) checkString() string {
-	var b []string
+// queryParam returns a weft.QueryParam literal for a.
+func (a parameter) queryParam(required bool) string {
+	s := fmt.Sprintf("weft.QueryParam{Name: %q", a.Id)
 
-	for _, v := range a {
-		b = append(b, v.Id)
+	if required {
+		s += ", Required: true"
 	}
 
-	s := strings.Join(b, `", "`)
+	if v := validator(a.Type); v != "" {
+		s += ", Valid: " + v
+	}
+
+	return s + "}"
+}
 
-	if s != "" {
-		s = `"` + s + `"`
+// validator returns the weft validator for values of type t.
+// Returns an empty string if values of t are not validated.
+func validator(t string) string {
+	switch strings.ToLower(t) {
+	case "int", "int32", "int64":
+		return "weft.Int()"
+	case "float", "float32", "float64", "number":
+		return "weft.Float()"
+	case "time", "rfc3339":
+		return "weft.Time()"
+	case "bbox", "boundingbox":
+		return "weft.BoundingBox()"
 	}
 
-	return s
+	return ""
 }
 
 func (a *api) read(filename string) error {
@@ -199,6 +265,10 @@ func (a *api) read(filename string) error {
 		for j := range a.Endpoint[i].Request {
 			a.Endpoint[i].Request[j].Uri = a.Endpoint[i].Uri
 
+			if len(a.Endpoint[i].Request[j].Roles) > 0 {
+				a.Endpoint[i].Request[j].Auth = true
+			}
+
 			if a.Endpoint[i].Request[j].Parameter != "" {
 				p, ok := a.Query[a.Endpoint[i].Request[j].Parameter]
 				if !ok {
@@ -325,6 +395,7 @@ func (a *api) writeHandlers(filename string) error {
 				}
 
 				b.WriteString(fmt.Sprintf("case \"%s\":\n", r.Accept))
+				r.authorize(&b)
 				r.checkQuery(&b)
 				b.WriteString(fmt.Sprintf("h.Set(\"Content-Type\", \"%s\")\n", r.Accept))
 				b.WriteString(fmt.Sprintf("return %s(r, h, b)\n", r.Function))
@@ -332,6 +403,7 @@ func (a *api) writeHandlers(filename string) error {
 
 			b.WriteString("default:\n")
 			if hasDefault {
+				d.authorize(&b)
 				d.checkQuery(&b)
 				b.WriteString(fmt.Sprintf("h.Set(\"Content-Type\", \"%s\")\n", d.Accept))
 				b.WriteString(fmt.Sprintf("return %s(r, h, b)\n", d.Function))
@@ -350,6 +422,7 @@ func (a *api) writeHandlers(filename string) error {
 
 		if len(put) == 1 {
 			b.WriteString(`case "PUT":` + "\n")
+			put[0].authorize(&b)
 			put[0].checkQuery(&b)
 			b.WriteString(fmt.Sprintf("return %s(r, h, b)\n", put[0].Function))
 		}
@@ -362,6 +435,7 @@ func (a *api) writeHandlers(filename string) error {
 
 		if len(delete) == 1 {
 			b.WriteString(`case "DELETE":` + "\n")
+			delete[0].authorize(&b)
 			delete[0].checkQuery(&b)
 			b.WriteString(fmt.Sprintf("return %s(r, h, b)\n", delete[0].Function))
 		}
@@ -409,6 +483,4 @@ func (a *api) writeDocs(filename string) error {
 	}
 
 	return f.Sync()
-
-	return err
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"go/format"
+	"io/ioutil"
 	"testing"
 )
 
@@ -19,3 +22,86 @@ func TestLoadApi(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestCheckQuery(t *testing.T) {
+	r := request{
+		R: Parameter{{Id: "time", Type: "time"}, {Id: "typeID", Type: "int"}},
+		O: Parameter{{Id: "resolution", Type: "string"}},
+	}
+
+	var b bytes.Buffer
+	r.checkQuery(&b)
+
+	e := `if res := weft.ValidateQuery(r,
+weft.QueryParam{Name: "time", Required: true, Valid: weft.Time()},
+weft.QueryParam{Name: "typeID", Required: true, Valid: weft.Int()},
+weft.QueryParam{Name: "resolution"}); !res.Ok {
+return res
+}
+`
+	if b.String() != e {
+		t.Errorf("expected %s got %s", e, b.String())
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	var b bytes.Buffer
+
+	request{}.authorize(&b)
+
+	if b.Len() != 0 {
+		t.Errorf("expected no authorization got %s", b.String())
+	}
+
+	request{Auth: true, Roles: []string{"editor", "admin"}}.authorize(&b)
+
+	e := `var res *weft.Result
+if r, res = weft.Authenticate(r, h, authenticator, "editor", "admin"); !res.Ok {
+return res
+}
+`
+	if b.String() != e {
+		t.Errorf("expected %s got %s", e, b.String())
+	}
+}
+
+func TestReadRoles(t *testing.T) {
+	a := api{}
+
+	if err := a.read("etc/weft_api.toml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.HasAuth() {
+		t.Error("expected requests with authorization")
+	}
+
+	for _, e := range a.Endpoint {
+		for _, r := range e.Request {
+			if r.Auth != (r.Method == "PUT" || r.Method == "DELETE") {
+				t.Errorf("unexpected Auth %t for %s %s", r.Auth, r.Method, r.Uri)
+			}
+		}
+	}
+}
+
+func TestHandlersFormat(t *testing.T) {
+	a := api{}
+
+	if err := a.read("etc/weft_api.toml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.writeHandlers("etc/handlers_auto.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile("etc/handlers_auto.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := format.Source(b); err != nil {
+		t.Errorf("generated handlers are not valid Go: %s", err)
+	}
+}
@@ -0,0 +1,39 @@
+package wefttest
+
+import (
+	"sync"
+)
+
+// Purger is an in memory weft.Purger for testing handlers that purge
+// surrogate keys.  It records the keys purged.  The zero value is ready to use.
+type Purger struct {
+	mu   sync.Mutex
+	keys []string
+	Err  error // returned by Purge if non nil.
+}
+
+// Purge records keys and returns p.Err.
+func (p *Purger) Purge(keys ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = append(p.keys, keys...)
+
+	return p.Err
+}
+
+// Purged returns the keys purged so far in the order they were purged.
+func (p *Purger) Purged() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.keys...)
+}
+
+// Reset clears the keys purged so far.
+func (p *Purger) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = nil
+}